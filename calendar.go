@@ -10,36 +10,40 @@ import (
 	"time"
 )
 
-func getCalendar(params ...interface{}) {
+func getCalendar(ctx context.Context, caldata *calendarData) error {
 	var (
 		err                           error
 		c                             *msgraph.Client
 		tenantid, clientid, clientkey string
+		upcomingEvent                 bool
+		lines                         [2]string
 	)
 	logger.Debug().Msg("Getting Calendar")
-	caldata := params[0].(*calendarData)
-	caldata.upcomingEvent = false
-	caldata.lines[0] = "See"
-	caldata.lines[1] = "receptionist"
+	// Publish whatever we end up with, including the fallback text on error
+	defer func() {
+		caldata.set(upcomingEvent, lines)
+	}()
+	lines[0] = "See"
+	lines[1] = "receptionist"
 	tenantid = os.Getenv("AZURE_TENANTID")
 	clientid = os.Getenv("AZURE_CLIENTID")
 	clientkey = os.Getenv("AZURE_CLIENTKEY")
 	if len(tenantid) == 0 {
 		logger.Error().Msg("Missing environment variable AZURE_TENANTID")
-		return
+		return nil
 	}
 	if len(clientid) == 0 {
 		logger.Error().Msg("Missing environment variable AZURE_CLIENTID")
-		return
+		return nil
 	}
 	if len(clientkey) == 0 {
 		logger.Error().Msg("Missing environment variable AZURE_CLIENTKEY")
-		return
+		return nil
 	}
-	c, err = msgraph.NewKeyClient(context.Background(), tenantid, clientid, clientkey)
+	c, err = msgraph.NewKeyClient(ctx, tenantid, clientid, clientkey)
 	if err != nil {
 		logger.Error().Err(err).Send()
-		return
+		return err
 	}
 	defer c.Close()
 	daystart := godate.Create(time.Now())
@@ -47,32 +51,33 @@ func getCalendar(params ...interface{}) {
 		msgraph.OptionStartDateTime(daystart.Time), msgraph.OptionEndDateTime(daystart.EndOfDay().Time))
 	if err != nil {
 		logger.Error().Err(err).Send()
-		return
+		return err
 	}
 	sort.Slice(events, func(i, j int) bool {
 		return events[i].Start.Native.Before(events[j].Start.Native)
 	})
 	logger.Debug().Msgf("Got %d events", len(events))
 	if len(events) == 0 {
-		caldata.upcomingEvent = false
-		caldata.lines[0] = "Available"
-		caldata.lines[1] = ""
+		upcomingEvent = false
+		lines[0] = "Available"
+		lines[1] = ""
 	} else {
 		logger.Debug().Msgf("%+v", events[0])
-		caldata.upcomingEvent = true
+		upcomingEvent = true
 		s := events[0].Start.Native.Local()
 		e := events[0].End.Native.Local()
 		if s.Add(-5 * time.Minute).After(time.Now()) {
 			// Event is upcoming within 5 minutes
-			caldata.lines[0] = events[0].Subject
-			caldata.lines[1] = fmt.Sprintf("%s…%s", s.Format("3:04"), e.Format("3:04"))
+			lines[0] = events[0].Subject
+			lines[1] = fmt.Sprintf("%s…%s", s.Format("3:04"), e.Format("3:04"))
 		} else if s.After(time.Now()) {
-			caldata.lines[0] = "Available"
-			caldata.lines[1] = fmt.Sprintf("until %s", s.Format("3:04"))
+			lines[0] = "Available"
+			lines[1] = fmt.Sprintf("until %s", s.Format("3:04"))
 		} else {
-			caldata.lines[0] = events[0].Subject
-			caldata.lines[1] = fmt.Sprintf("until %s", e.Format("3:04"))
+			lines[0] = events[0].Subject
+			lines[1] = fmt.Sprintf("until %s", e.Format("3:04"))
 		}
 	}
-	logger.Debug().Strs("lines", caldata.lines[:]).Send()
+	logger.Debug().Strs("lines", lines[:]).Send()
+	return nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
@@ -8,7 +9,7 @@ import (
 	"os"
 )
 
-func getMarketData(params ...interface{}) {
+func getMarketData(ctx context.Context, mktdata *marketData) error {
 	var (
 		err      error
 		rapidkey string
@@ -62,29 +63,38 @@ func getMarketData(params ...interface{}) {
 		}
 	)
 	logger.Debug().Msg("Getting Market Data")
-	mktdata := params[0].(*marketData)
 	rapidkey = os.Getenv("RAPIDAPI_KEY")
 	if len(rapidkey) == 0 {
 		logger.Error().Msg("Missing environment variable RAPIDAPI_KEY")
-		return
+		return nil
 	}
 	url := "https://apidojo-yahoo-finance-v1.p.rapidapi.com/market/get-summary?region=US&lang=en"
-	req, _ := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
 	req.Header.Add("x-rapidapi-host", "apidojo-yahoo-finance-v1.p.rapidapi.com")
 	req.Header.Add("x-rapidapi-key", rapidkey)
-	res, _ := http.DefaultClient.Do(req)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Error().Err(err).Msg("error calling rapidApi")
+		return err
+	}
 	defer res.Body.Close()
 	err = json.NewDecoder(res.Body).Decode(&result)
 	if err != nil {
 		logger.Error().Err(err).Msg("error decoding JSON from rapidApi")
+		return err
 	} else if result.MarketSummaryResponse.Error != nil {
 		logger.Error().Msg(spew.Sdump(result.MarketSummaryResponse.Error))
+		return fmt.Errorf("rapidApi error: %v", result.MarketSummaryResponse.Error)
 	} else {
 		for _, r := range result.MarketSummaryResponse.Result {
 			if r.Symbol == "^IXIC" {
-				mktdata.lines[0] = "NASDAQ"
-				mktdata.lines[1] = fmt.Sprintf("%s %s", r.RegularMarketPrice.Fmt, r.RegularMarketChangePercent.Fmt)
+				mktdata.set([2]string{"NASDAQ",
+					fmt.Sprintf("%s %s", r.RegularMarketPrice.Fmt, r.RegularMarketChangePercent.Fmt)})
 			}
 		}
 	}
+	return nil
 }
@@ -6,10 +6,14 @@ import (
 	"github.com/fogleman/gg"
 	"github.com/jjcinaz/panelserver/minicron"
 	"github.com/jjcinaz/panelserver/pixelpusher"
+	"github.com/jjcinaz/panelserver/pixelpusher/server"
 	"golang.org/x/image/font/inconsolata"
 	"image"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,20 +25,31 @@ var (
 	debugmode    bool
 	rows, cols   int
 	address      string
+	statefile    string
+	versioned    bool
+	gamma        float64
+	brightness   float64
+	emulate      string
+	ackTimeout   time.Duration
 	pgmTerminate context.CancelFunc
 	logger       zerolog.Logger
 )
 
+// Jobs run concurrently so the data shared between them is guarded by a mutex
 type calendarData struct {
+	mutex         sync.Mutex
 	upcomingEvent bool
 	lines         [2]string
 }
 
 type marketData struct {
+	mutex sync.Mutex
 	lines [2]string
 }
 
 type PanelData struct {
+	panel       *pixelpusher.Client
+	online      bool // panel status last logged, when frames are acknowledged
 	displaymode int
 	caldata     calendarData
 	mktdata     marketData
@@ -49,6 +64,12 @@ func main() {
 	flag.IntVar(&rows, "rows", 0, "Rows on RGB matrix panel")
 	flag.IntVar(&cols, "cols", 0, "Cols on RGB matrix panel")
 	flag.StringVar(&address, "address", "", "IP address of panel")
+	flag.StringVar(&statefile, "state", "", "File to save job state in so restarts don't refetch everything")
+	flag.BoolVar(&versioned, "versioned", false, "Send packets with a versioned header, needed for panels over 256 rows")
+	flag.Float64Var(&gamma, "gamma", 1, "Gamma correction for the panel's LEDs, around 2.2 for most panels")
+	flag.Float64Var(&brightness, "brightness", 1, "Panel brightness, 0...1")
+	flag.DurationVar(&ackTimeout, "ack", 0, "Have the panel acknowledge frames, resending any not acknowledged within this long; needs -versioned")
+	flag.StringVar(&emulate, "emulate", "", "Draw on a software panel served to a browser at this address instead of a real panel")
 	flag.Parse()
 	if debugmode {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
@@ -57,61 +78,146 @@ func main() {
 	} else {
 		logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 	}
-
+	// One client for the life of the program so the panel sees an unbroken frame sequence
+	var err error
+	protocol := pixelpusher.ProtocolLegacy
+	if versioned {
+		protocol = pixelpusher.ProtocolV1
+	}
 	ctxExiting, pgmTerminate = context.WithCancel(context.Background())
+	if len(emulate) > 0 {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to start panel emulator")
+		}
+		emu := server.New(protocol, rows, cols)
+		go emu.Serve(ctxExiting, conn)
+		go func() {
+			logger.Error().Err(http.ListenAndServe(emulate, emu)).Msg("panel emulator web server stopped")
+		}()
+		address = conn.LocalAddr().String()
+	}
+	panelOpts := []pixelpusher.Option{pixelpusher.WithProtocol(protocol), pixelpusher.WithGamma(gamma),
+		pixelpusher.WithBrightness(brightness)}
+	if ackTimeout > 0 {
+		panelOpts = append(panelOpts, pixelpusher.WithAck(ackTimeout, 3))
+	}
+	if paneldata.panel, err = pixelpusher.NewClient("udp", address, rows, cols, panelOpts...); err != nil {
+		logger.Fatal().Err(err).Msg("invalid panel configuration")
+	}
+	defer paneldata.panel.Close()
+
+	schedOpts := []minicron.Option{
+		minicron.WithErrorHandler(func(err error) {
+			logger.Error().Err(err).Msg("scheduler")
+		}),
+		minicron.WithHooks(minicron.Hooks{
+			After: func(info minicron.JobInfo, err error, elapsed time.Duration) {
+				logger.Debug().Str("job", info.Name).Dur("elapsed", elapsed).Err(err).
+					Uint64("runs", info.Stats.Runs).Uint64("skipped", info.Stats.Skipped).Msg("job finished")
+			},
+			Panic: func(info minicron.JobInfo, recovered interface{}, stack []byte) {
+				logger.Error().Str("job", info.Name).Interface("panic", recovered).Bytes("stack", stack).Msg("job panicked")
+			},
+		}),
+		// Stay well inside the RapidAPI plan's request quota, including retries
+		minicron.WithRateLimit("rapidapi", minicron.RateLimit{Spacing: time.Second * 10, Every: time.Minute, Burst: 3}),
+	}
+	if len(statefile) > 0 {
+		store, err := minicron.NewFileStore(statefile)
+		if err != nil {
+			logger.Fatal().Err(err).Str("file", statefile).Msg("unable to load job state")
+		}
+		schedOpts = append(schedOpts, minicron.WithStateStore(store))
+	}
+	sched := minicron.NewSchedule(schedOpts...)
 	signalChannel := make(chan os.Signal, 2)
-	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
 	go func() {
-		sig := <-signalChannel
-		switch sig {
-		case os.Interrupt, syscall.SIGTERM:
-			pgmTerminate()
+		for sig := range signalChannel {
+			switch sig {
+			case os.Interrupt, syscall.SIGTERM:
+				pgmTerminate()
+			case syscall.SIGUSR1:
+				// Force a calendar refresh, e.g. after a room has just been booked
+				if h, ok := sched.Lookup("calendar"); ok {
+					logger.Info().Msg("Refreshing calendar")
+					sched.RunNow(h)
+				}
+			}
 		}
 	}()
-	sched := minicron.NewSchedule()
 	// InitWebServer()
-	sched.AddJob(time.Minute*5, true, getCalendar, &paneldata.caldata)
-	sched.AddJob(time.Minute*5, true, getMarketData, &paneldata.mktdata)
-	sched.AddJob(time.Second*5, true, updateRGBmatrix, &paneldata)
-	timer := time.NewTicker(time.Second)
-MAINLOOP:
-	for {
-		select {
-		case <-timer.C:
-			sched.ServiceNextJob()
-		case <-ctxExiting.Done():
-			break MAINLOOP
-		}
-	}
+	// Retry failed fetches well before the next 5 minute refresh
+	retry := minicron.WithRetry(minicron.Backoff{Initial: time.Second * 15, Max: time.Minute * 2})
+	// Jitter so several panels restarted together do not all call the same API at once
+	jitter := minicron.WithJitter(time.Second * 20)
+	hCal := minicron.AddTyped(sched, time.Minute*5, true, getCalendar, &paneldata.caldata,
+		minicron.WithName("calendar"), minicron.WithTimeout(time.Minute), retry, jitter)
+	hMkt := minicron.AddTyped(sched, time.Minute*5, true, getMarketData, &paneldata.mktdata,
+		minicron.WithName("market"), minicron.WithTimeout(time.Minute), retry, jitter, minicron.WithRateGroup("rapidapi"))
+	// Not named, there is nothing worth persisting about the panel refresh
+	hRender := minicron.AddTyped(sched, time.Second*5, true, updateRGBmatrix, &paneldata)
+	// show fresh data straight away rather than at the next refresh
+	sched.After(hRender, hCal)
+	sched.After(hRender, hMkt)
+	sched.Run(ctxExiting)
 	logger.Info().Msg("Exiting normally")
 }
 
-func updateRGBmatrix(params ...interface{}) {
-	paneldata := params[0].(*PanelData)
+func (d *calendarData) get() (bool, [2]string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.upcomingEvent, d.lines
+}
+
+func (d *calendarData) set(upcomingEvent bool, lines [2]string) {
+	d.mutex.Lock()
+	d.upcomingEvent, d.lines = upcomingEvent, lines
+	d.mutex.Unlock()
+}
+
+func (d *marketData) get() [2]string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.lines
+}
+
+func (d *marketData) set(lines [2]string) {
+	d.mutex.Lock()
+	d.lines = lines
+	d.mutex.Unlock()
+}
+
+func updateRGBmatrix(ctx context.Context, paneldata *PanelData) error {
+	var err error
 	logger.Debug().Int("displaymode", paneldata.displaymode).Msg("Updating RGB Matrix")
-	client, err := pixelpusher.NewClient("udp", address, rows, cols)
-	if err != nil {
-		logger.Error().Err(err)
-		return
+	client := paneldata.panel
+	if ackTimeout > 0 {
+		if h := client.Health(); h.Online != paneldata.online {
+			paneldata.online = h.Online
+			logger.Info().Bool("online", h.Online).Time("lastack", h.LastAck).Msg("Panel status changed")
+		}
 	}
-	defer client.Close()
+	upcomingEvent, _ := paneldata.caldata.get()
 	switch paneldata.displaymode {
 	case 0, 1, 2, 3:
 		err = client.SendImage(buildClockImage(paneldata))
-		if paneldata.caldata.upcomingEvent {
+		if upcomingEvent {
 			paneldata.displaymode++
 		} else {
 			paneldata.displaymode = 4
 		}
 	case 4:
 		err = client.SendImage(buildMarketImage(paneldata))
-		if paneldata.caldata.upcomingEvent {
+		if upcomingEvent {
 			paneldata.displaymode = 0
 		}
 	}
 	if err != nil {
 		fmt.Println(err)
 	}
+	return err
 }
 
 func buildClockImage(paneldata *PanelData) image.Image {
@@ -121,12 +227,13 @@ func buildClockImage(paneldata *PanelData) image.Image {
 	dc.Clear()
 	drawClock(dc, float64(cols)-32, 0, 32)
 	dc.SetRGB255(255, 255, 255)
-	s := paneldata.caldata.lines[0]
+	_, lines := paneldata.caldata.get()
+	s := lines[0]
 	if len(s) > 12 {
 		s = s[0:12]
 	}
 	dc.DrawStringAnchored(s, 0, 0, 0, 0.8)
-	s = paneldata.caldata.lines[1]
+	s = lines[1]
 	if len(s) > 12 {
 		s = s[0:12]
 	}
@@ -140,12 +247,13 @@ func buildMarketImage(paneldata *PanelData) image.Image {
 	dc.SetRGB255(0, 0, 0)
 	dc.Clear()
 	dc.SetRGB255(255, 255, 255)
-	s := paneldata.mktdata.lines[0]
+	lines := paneldata.mktdata.get()
+	s := lines[0]
 	if len(s) > 16 {
 		s = s[0:16]
 	}
 	dc.DrawStringAnchored(s, 0, 0, 0, 0.8)
-	s = paneldata.mktdata.lines[1]
+	s = lines[1]
 	if len(s) > 16 {
 		s = s[0:16]
 	}
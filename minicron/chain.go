@@ -0,0 +1,184 @@
+package minicron
+
+import (
+	"fmt"
+)
+
+// A set of jobs which must all succeed before the dependent job is triggered
+type trigger struct {
+	deps []Handle
+	done map[Handle]bool // deps which have succeeded since the trigger last fired
+}
+
+func (t *trigger) has(handle Handle) bool {
+	for _, h := range t.deps {
+		if h == handle {
+			return true
+		}
+	}
+	return false
+}
+
+// After makes a job run each time all of deps have completed successfully, in any order, since it was last
+// triggered by them.  Calling After again adds another independent trigger, so After(h, a, b) runs h once both
+// a and b have succeeded while After(h, a) followed by After(h, b) runs it whenever either succeeds.  The job
+// keeps its own schedule, if any.  An error is returned if a job is not found or the dependency would create a
+// cycle.
+func (sched *Schedule) After(handle Handle, deps ...Handle) error {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	return sched.addTrigger(handle, deps)
+}
+
+// AddTriggered adds a job with no schedule of its own which runs each time all of deps have succeeded (see After).
+func (sched *Schedule) AddTriggered(fn JobFunc, deps []Handle, opts ...JobOption) (Handle, error) {
+	if err := checkOptions(false, opts); err != nil {
+		return 0, err
+	}
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	j := &Job{
+		fn:        fn,
+		loc:       sched.loc,
+		triggered: true,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	sched.nextHandle++
+	j.handle = Handle(sched.nextHandle)
+	sched.insert(j)
+	if err := sched.addTrigger(j.handle, deps); err != nil {
+		sched.unlink(j)
+		return 0, err
+	}
+	return j.handle, nil
+}
+
+// mutex MUST be locked when running this
+func (sched *Schedule) addTrigger(handle Handle, deps []Handle) error {
+	j := sched.find(handle)
+	if j == nil {
+		return fmt.Errorf("job %d not found", handle)
+	}
+	if len(deps) == 0 {
+		return fmt.Errorf("no dependencies given")
+	}
+	t := &trigger{done: make(map[Handle]bool)}
+	var added []*Job
+	for _, h := range deps {
+		dep := sched.find(h)
+		if dep == nil {
+			return fmt.Errorf("dependency %d not found", h)
+		}
+		if h == handle || sched.dependsOn(dep, handle, make(map[Handle]bool)) {
+			return fmt.Errorf("job %d depending on %d would create a cycle", handle, h)
+		}
+		if !t.has(h) {
+			t.deps = append(t.deps, h)
+			added = append(added, dep)
+		}
+	}
+	j.after = append(j.after, t)
+	for _, dep := range added {
+		if !dep.triggers(j) {
+			dep.dependents = append(dep.dependents, j)
+		}
+	}
+	return nil
+}
+
+// Report whether j is in one of other's triggers
+func (j *Job) triggers(other *Job) bool {
+	for _, d := range j.dependents {
+		if d == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether j is triggered, directly or indirectly, by the job target
+// mutex MUST be locked when running this
+func (sched *Schedule) dependsOn(j *Job, target Handle, seen map[Handle]bool) bool {
+	for _, t := range j.after {
+		for _, h := range t.deps {
+			if h == target {
+				return true
+			}
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			if dep := sched.find(h); dep != nil && sched.dependsOn(dep, target, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Record a successful run of j and start any jobs whose triggers are now complete
+// mutex MUST be locked when running this
+func (sched *Schedule) fireTriggers(j *Job) {
+	for _, dependent := range j.dependents {
+		if dependent.paused {
+			continue
+		}
+		fire := false
+		for _, t := range dependent.after {
+			if !t.has(j.handle) {
+				continue
+			}
+			t.done[j.handle] = true
+			if len(t.done) == len(t.deps) {
+				t.done = make(map[Handle]bool)
+				fire = true
+			}
+		}
+		if fire && dependent.active(sched.clock.Now()) {
+			dependent.lastRun = sched.clock.Now()
+			sched.dispatch(dependent)
+		}
+	}
+}
+
+// Remove a job being taken out of the schedule from every trigger, triggers left empty are dropped
+// mutex MUST be locked when running this
+func (sched *Schedule) removeTriggers(removed *Job) {
+	for _, j := range removed.dependents {
+		after := j.after[:0]
+		for _, t := range j.after {
+			if t.has(removed.handle) {
+				deps := t.deps[:0]
+				for _, h := range t.deps {
+					if h != removed.handle {
+						deps = append(deps, h)
+					}
+				}
+				t.deps = deps
+				delete(t.done, removed.handle)
+			}
+			if len(t.deps) > 0 {
+				after = append(after, t)
+			}
+		}
+		j.after = after
+	}
+	removed.dependents = nil
+	// and stop the jobs it depends on holding on to it
+	for _, t := range removed.after {
+		for _, h := range t.deps {
+			if dep := sched.find(h); dep != nil {
+				dependents := dep.dependents[:0]
+				for _, d := range dep.dependents {
+					if d != removed {
+						dependents = append(dependents, d)
+					}
+				}
+				dep.dependents = dependents
+			}
+		}
+	}
+	removed.after = nil
+}
@@ -0,0 +1,152 @@
+package minicron
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A JobFunc which counts its runs and returns whatever *result holds
+func countRuns(runs *int, result *error) JobFunc {
+	return func(context.Context) error {
+		*runs++
+		return *result
+	}
+}
+
+func TestSchedule_After(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	var (
+		ok                error
+		calRuns, mktRuns  int
+		anyRuns, bothRuns int
+	)
+	hCal := s.AddFunc(time.Minute, false, countRuns(&calRuns, &ok))
+	hMkt := s.AddFunc(time.Minute*2, false, countRuns(&mktRuns, &ok))
+	// both dependencies run at once at 10:02, queue so the second trigger is not dropped
+	hAny := s.AddFunc(time.Hour, false, countRuns(&anyRuns, &ok), WithOverlap(OverlapQueue))
+	if err := s.After(hAny, hCal); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.After(hAny, hMkt); err != nil {
+		t.Fatal(err)
+	}
+	hBoth, err := s.AddTriggered(countRuns(&bothRuns, &ok), []Handle{hCal, hMkt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := s.Job(hBoth); !info.NextRun.IsZero() || len(info.After) != 1 || len(info.After[0]) != 2 {
+		t.Errorf("triggered job %+v", info)
+	}
+	fc.Advance(time.Minute)
+	serviceDue(s)
+	if anyRuns != 1 || bothRuns != 0 {
+		t.Errorf("after first dependency any=%d both=%d", anyRuns, bothRuns)
+	}
+	fc.Advance(time.Minute)
+	serviceDue(s)
+	if anyRuns != 3 || bothRuns != 1 {
+		t.Errorf("after both dependencies any=%d both=%d", anyRuns, bothRuns)
+	}
+	// a fan-in trigger starts over once it fires
+	fc.Advance(time.Minute)
+	serviceDue(s)
+	if calRuns != 3 || bothRuns != 1 {
+		t.Errorf("fan-in fired without second dependency cal=%d both=%d", calRuns, bothRuns)
+	}
+	if info, _ := s.Job(hBoth); !info.LastRun.Equal(t0.Add(time.Minute * 2)) {
+		t.Errorf("triggered job last run %v", info.LastRun)
+	}
+}
+
+func TestSchedule_After_failure(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	var (
+		result           error = errors.New("fail")
+		ok               error
+		runs, dependRuns int
+	)
+	h := s.AddFunc(time.Minute, false, countRuns(&runs, &result))
+	if _, err := s.AddTriggered(countRuns(&dependRuns, &ok), []Handle{h}); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(time.Minute)
+	serviceDue(s)
+	if runs != 1 || dependRuns != 0 {
+		t.Errorf("failed run triggered dependent runs=%d dependent=%d", runs, dependRuns)
+	}
+	result = nil
+	s.RunNow(h)
+	s.running.Wait()
+	if dependRuns != 1 {
+		t.Errorf("successful run did not trigger dependent")
+	}
+}
+
+func TestSchedule_After_cycle(t *testing.T) {
+	s := NewSchedule(WithClock(NewFakeClock(t0)))
+	a := s.AddFunc(time.Minute, false, nil)
+	b, _ := s.AddTriggered(nil, []Handle{a})
+	c, _ := s.AddTriggered(nil, []Handle{b})
+	if err := s.After(a, c); err == nil {
+		t.Errorf("cycle a -> b -> c -> a accepted")
+	}
+	if err := s.After(a, a); err == nil {
+		t.Errorf("job depending on itself accepted")
+	}
+	if _, err := s.AddTriggered(nil, []Handle{999}); err == nil {
+		t.Errorf("unknown dependency accepted")
+	}
+	if err := s.After(c, a); err != nil {
+		t.Errorf("fan-in rejected: %v", err)
+	}
+	s.RemoveJob(b)
+	if info, _ := s.Job(c); len(info.After) != 1 || info.After[0][0] != a {
+		t.Errorf("removed job left in triggers %+v", info.After)
+	}
+	if len(s.Jobs()) != 2 {
+		t.Errorf("failed AddTriggered left a job in the schedule")
+	}
+}
+
+func TestSchedule_After_once(t *testing.T) {
+	fc := NewFakeClock(t0)
+	var reported error
+	s := NewSchedule(WithClock(fc), WithErrorHandler(func(err error) { reported = err }))
+	var (
+		ok                            error
+		onceRuns, afterRuns, trigRuns int
+	)
+	hOnce := s.AddOnce(t0.Add(time.Minute), countRuns(&onceRuns, &ok))
+	hAfter := s.AddFunc(time.Hour, false, countRuns(&afterRuns, &ok))
+	if err := s.After(hAfter, hOnce); err != nil {
+		t.Fatal(err)
+	}
+	hTrig, err := s.AddTriggered(countRuns(&trigRuns, &ok), []Handle{hOnce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.Set(t0.Add(time.Minute))
+	serviceDue(s)
+	if onceRuns != 1 || afterRuns != 1 || trigRuns != 1 {
+		t.Errorf("runs: once %d, after %d, triggered %d", onceRuns, afterRuns, trigRuns)
+	}
+	// the one-shot has gone and its triggers with it
+	if _, ok := s.Job(hOnce); ok {
+		t.Errorf("one-shot still scheduled")
+	}
+	if info, _ := s.Job(hTrig); len(info.After) != 0 {
+		t.Errorf("finished one-shot left in triggers %+v", info.After)
+	}
+	// a one-shot cannot be retried
+	if h := s.AddOnce(t0, nil, WithRetry(Backoff{Initial: time.Second})); h != 0 || reported == nil {
+		t.Errorf("one-shot with retry gave handle %d, error %v", h, reported)
+	}
+	h := s.AddOnce(t0.Add(time.Hour), nil)
+	if err := s.SetOptions(h, WithRetry(Backoff{Initial: time.Second})); err == nil {
+		t.Errorf("SetOptions gave a one-shot a retry")
+	}
+}
@@ -0,0 +1,157 @@
+package minicron
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for a Schedule.  RealClock is used unless WithClock is given; tests use a
+// FakeClock to control exactly when jobs become due.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of time.Timer used by a Schedule
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// RealClock is a Clock backed by the time package
+type RealClock struct{}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a manually advanced Clock.  Timers created from it fire when Advance or Set moves the clock to
+// or past their expiry.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	active bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+// Advance moves the clock forward by d, firing any timers which expire
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	fc.setLocked(fc.now.Add(d))
+	fc.mutex.Unlock()
+}
+
+// Set moves the clock to t, firing any timers which expire.  Moving the clock backwards fires nothing.
+func (fc *FakeClock) Set(t time.Time) {
+	fc.mutex.Lock()
+	fc.setLocked(t)
+	fc.mutex.Unlock()
+}
+
+// Timers returns the number of timers waiting to fire
+func (fc *FakeClock) Timers() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return len(fc.timers)
+}
+
+// mutex MUST be locked when running this
+func (fc *FakeClock) setLocked(t time.Time) {
+	fc.now = t
+	active := fc.timers[:0]
+	for _, ft := range fc.timers {
+		if ft.when.After(t) {
+			active = append(active, ft)
+		} else {
+			ft.fire(t)
+		}
+	}
+	fc.timers = active
+}
+
+func (fc *FakeClock) NewTimer(d time.Duration) Timer {
+	ft := &fakeTimer{
+		clock: fc,
+		c:     make(chan time.Time, 1),
+	}
+	ft.Reset(d)
+	return ft
+}
+
+func (ft *fakeTimer) C() <-chan time.Time {
+	return ft.c
+}
+
+// mutex of the clock MUST be locked when running this
+func (ft *fakeTimer) fire(t time.Time) {
+	ft.active = false
+	select {
+	case ft.c <- t:
+	default:
+	}
+}
+
+// mutex of the clock MUST be locked when running this
+func (ft *fakeTimer) stop() bool {
+	if !ft.active {
+		return false
+	}
+	ft.active = false
+	for i := range ft.clock.timers {
+		if ft.clock.timers[i] == ft {
+			ft.clock.timers = append(ft.clock.timers[:i], ft.clock.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (ft *fakeTimer) Stop() bool {
+	ft.clock.mutex.Lock()
+	defer ft.clock.mutex.Unlock()
+	return ft.stop()
+}
+
+// Reset re-arms the timer, a timer reset to a non-positive duration fires immediately
+func (ft *fakeTimer) Reset(d time.Duration) bool {
+	ft.clock.mutex.Lock()
+	defer ft.clock.mutex.Unlock()
+	wasActive := ft.stop()
+	ft.when = ft.clock.now.Add(d)
+	if d <= 0 {
+		ft.fire(ft.clock.now)
+	} else {
+		ft.active = true
+		ft.clock.timers = append(ft.clock.timers, ft)
+	}
+	return wasActive
+}
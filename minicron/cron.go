@@ -0,0 +1,253 @@
+package minicron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSpec is a parsed cron expression.  Each field is held as a bitmask of the values it matches.
+type CronSpec struct {
+	spec                                  string
+	second, minute, hour, dom, month, dow uint64
+	// When both day-of-month and day-of-week are restricted a day matches if either one does (Vixie cron rules)
+	domAny, dowAny bool
+	hourAny        bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week allows 7 as an alias for Sunday; it is folded into bit 0 after parsing
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseCron parses a standard 5 field (minute hour dom month dow) or 6 field (second minute hour dom month dow)
+// cron expression.  Fields accept lists (1,15), ranges (9-17), steps (*/5, 10-50/10) and, for month and day of
+// week, three letter names (JAN, MON-FRI).  The descriptors @yearly, @monthly, @weekly, @daily and @hourly are
+// also accepted.
+func ParseCron(spec string) (*CronSpec, error) {
+	var err error
+	spec = strings.TrimSpace(spec)
+	fields := strings.Fields(spec)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		fields = strings.Fields(d)
+	}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron spec %q must have 5 or 6 fields", spec)
+	}
+	cs := &CronSpec{spec: spec}
+	if cs.second, err = secondField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if cs.minute, err = minuteField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if cs.hour, err = hourField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if cs.dom, err = domField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if cs.month, err = monthField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if cs.dow, err = dowField.parse(fields[5]); err != nil {
+		return nil, err
+	}
+	if cs.dow&(1<<7) != 0 {
+		cs.dow = (cs.dow | 1) &^ (1 << 7)
+	}
+	cs.domAny = isWildcard(fields[3])
+	cs.dowAny = isWildcard(fields[5])
+	cs.hourAny = isWildcard(fields[2])
+	return cs, nil
+}
+
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var mask uint64
+	for _, term := range strings.Split(field, ",") {
+		var (
+			err        error
+			lo, hi     int
+			step       = 1
+			rangeTerm  = term
+			stepString string
+		)
+		if i := strings.IndexByte(term, '/'); i != -1 {
+			rangeTerm, stepString = term[:i], term[i+1:]
+			if step, err = strconv.Atoi(stepString); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepString, f.name)
+			}
+		}
+		switch {
+		case rangeTerm == "*" || rangeTerm == "?":
+			lo, hi = f.min, f.max
+			if f.max == 7 {
+				// don't let */n on day of week select Sunday twice
+				hi = 6
+			}
+		case strings.IndexByte(rangeTerm, '-') > 0:
+			i := strings.IndexByte(rangeTerm, '-')
+			if lo, err = f.value(rangeTerm[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(rangeTerm[i+1:]); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangeTerm, f.name)
+			}
+		default:
+			if lo, err = f.value(rangeTerm); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(stepString) > 0 {
+				// "a/n" means starting at a through the end of the range
+				hi = f.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be %d...%d", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (cs *CronSpec) dayMatches(t time.Time) bool {
+	domMatch := cs.dom&(1<<uint(t.Day())) != 0
+	dowMatch := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.domAny || cs.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time matching the spec which is strictly after t, in t's location.  A zero time is
+// returned if nothing matches within five years (e.g. "0 0 30 2 *").
+//
+// Daylight saving transitions follow Vixie cron.  With a fixed hour, a time skipped when the clock goes forward
+// matches as soon as the skipped hour ends and a time repeated when the clock goes back matches only once.  With
+// a wildcard hour elapsed time is followed, so a repeated hour is run through normally.
+func (cs *CronSpec) Next(t time.Time) time.Time {
+	var done bool
+	loc := t.Location()
+	// Round up to the next whole second
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	yearLimit := t.Year() + 5
+	for t.Year() <= yearLimit {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !cs.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			// step by elapsed time as time.Date can resolve an hour skipped by daylight saving to before t
+			next := t.Add(time.Duration(60-t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+			if t, done = cs.step(t, next); done {
+				return t
+			}
+			continue
+		}
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			if t, done = cs.step(t, t.Truncate(time.Minute).Add(time.Minute)); done {
+				return t
+			}
+			continue
+		}
+		if cs.second&(1<<uint(t.Second())) == 0 {
+			if t, done = cs.step(t, t.Add(time.Second)); done {
+				return t
+			}
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Move the search in Next from t to next, allowing for a daylight saving transition between them.  Returns
+// true if next is the end of a skipped hour containing a match, so should be the result of Next.
+func (cs *CronSpec) step(t, next time.Time) (time.Time, bool) {
+	if cs.hourAny || next.Day() != t.Day() {
+		return next, false
+	}
+	if next.Hour() > t.Hour()+1 {
+		// clock went forward
+		for h := t.Hour() + 1; h < next.Hour(); h++ {
+			if cs.hour&(1<<uint(h)) != 0 {
+				return next, true
+			}
+		}
+	} else if wallClock(next) < wallClock(t) {
+		// clock went back, skip the repeated times which have already been searched
+		return forward(next, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())), false
+	}
+	return next, false
+}
+
+// Guard against time.Date resolving a wall clock time in a daylight saving gap to before t, so Next always
+// makes progress
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Hour)
+}
+
+func wallClock(t time.Time) int {
+	return t.Hour()*3600 + t.Minute()*60 + t.Second()
+}
+
+// String returns the expression the spec was parsed from
+func (cs *CronSpec) String() string {
+	return cs.spec
+}
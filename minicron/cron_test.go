@@ -0,0 +1,96 @@
+package minicron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_errors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * FOO *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) expected error", spec)
+		}
+	}
+}
+
+func TestCronSpec_Next(t *testing.T) {
+	// 2021-03-01 is a Monday
+	base := time.Date(2021, 3, 1, 10, 15, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", base, time.Date(2021, 3, 1, 10, 16, 0, 0, time.UTC)},
+		{"58,28 * * * *", base, time.Date(2021, 3, 1, 10, 28, 0, 0, time.UTC)},
+		{"58,28 * * * *", time.Date(2021, 3, 1, 10, 28, 0, 0, time.UTC), time.Date(2021, 3, 1, 10, 58, 0, 0, time.UTC)},
+		{"*/15 * * * *", base, time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"*/10 * * * * *", base, time.Date(2021, 3, 1, 10, 15, 40, 0, time.UTC)},
+		{"30 9-16 * * MON-FRI", base, time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"30 9-16 * * mon-fri", time.Date(2021, 3, 5, 16, 30, 0, 0, time.UTC), time.Date(2021, 3, 8, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", base, time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 JAN *", base, time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", base, time.Date(2021, 3, 1, 10, 25, 0, 0, time.UTC)},
+		// dom and dow both restricted: either matches
+		{"0 0 15 * FRI", base, time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", base, time.Date(2021, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"@daily", base, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", base, time.Time{}},
+	}
+	for _, tt := range tests {
+		cs, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q) error %v", tt.spec, err)
+			continue
+		}
+		if got := cs.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestSchedule_AddCronJob(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 15, 30, 0, time.UTC)
+	fc := NewFakeClock(now)
+	s := NewSchedule(WithClock(fc))
+	h, err := s.AddCronJob("58,28 * * * *", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddJob(time.Hour, false, nil)
+	if s.jobs[0].handle != h || !s.jobs[0].nextRun.Equal(time.Date(2021, 3, 1, 10, 28, 0, 0, time.UTC)) {
+		t.Fatalf("cron job not first in schedule: %+v", s.jobs[0])
+	}
+	for _, step := range []struct {
+		at   time.Time
+		runs int
+	}{
+		{time.Date(2021, 3, 1, 10, 27, 59, 0, time.UTC), 0},
+		{time.Date(2021, 3, 1, 10, 28, 0, 0, time.UTC), 1},
+		{time.Date(2021, 3, 1, 10, 28, 1, 0, time.UTC), 1},
+		{time.Date(2021, 3, 1, 10, 58, 0, 0, time.UTC), 2},
+	} {
+		fc.Set(step.at)
+		s.ServiceNextJob()
+		s.running.Wait()
+		if stats, _ := s.Stats(h); stats.Runs != uint64(step.runs) {
+			t.Errorf("at %v got %d runs, want %d", step.at, stats.Runs, step.runs)
+		}
+	}
+	if _, err = s.AddCronJob("0 0 30 2 *", nil); err == nil {
+		t.Errorf("expected error for cron spec which never matches")
+	}
+}
@@ -0,0 +1,97 @@
+package minicron
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// jobHeap orders jobs by the next one which needs to run, paused and triggered jobs come after all others.  It
+// implements heap.Interface, each job keeping its own position in index.
+type jobHeap []*Job
+
+func (h jobHeap) Len() int {
+	return len(h)
+}
+
+func (h jobHeap) Less(i, j int) bool {
+	return h[i].before(h[j])
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	j := x.(*Job)
+	j.index = len(*h)
+	*h = append(*h, j)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*h = old[:n-1]
+	return j
+}
+
+// Report whether j runs before other
+func (j *Job) before(other *Job) bool {
+	if j.idle() != other.idle() {
+		return other.idle()
+	}
+	if j.due().Equal(other.due()) {
+		return j.handle < other.handle
+	}
+	return j.due().Before(other.due())
+}
+
+// Return the job which runs next, nil if the schedule is empty
+// mutex MUST be locked when running this
+func (sched *Schedule) head() *Job {
+	if len(sched.jobs) == 0 {
+		return nil
+	}
+	return sched.jobs[0]
+}
+
+// Restore the job's position after its next run time or idle state changed, waking Run if it is or was first
+// mutex MUST be locked when running this
+func (sched *Schedule) fix(j *Job) {
+	wasHead := j.index == 0
+	heap.Fix(&sched.jobs, j.index)
+	if wasHead || j.index == 0 {
+		sched.wakeup()
+	}
+}
+
+// Remove the job from the schedule, waking Run if it was first
+// mutex MUST be locked when running this
+func (sched *Schedule) unlink(j *Job) {
+	sched.detach(j)
+	j.removed = true
+}
+
+// Take the job out of the schedule's heap and index
+// mutex MUST be locked when running this
+func (sched *Schedule) detach(j *Job) {
+	if j.index == 0 {
+		sched.wakeup()
+	}
+	heap.Remove(&sched.jobs, j.index)
+	delete(sched.byHandle, j.handle)
+}
+
+// Return the jobs in the order they will next run
+// mutex MUST be locked when running this
+func (sched *Schedule) sorted() []*Job {
+	jobs := append([]*Job(nil), sched.jobs...)
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].before(jobs[k])
+	})
+	return jobs
+}
@@ -0,0 +1,121 @@
+package minicron
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Check the heap's ordering and bookkeeping
+func checkHeap(t *testing.T, s *Schedule) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.byHandle) != len(s.jobs) {
+		t.Fatalf("%d jobs in heap but %d by handle", len(s.jobs), len(s.byHandle))
+	}
+	for i, j := range s.jobs {
+		if j.index != i || s.byHandle[j.handle] != j || j.removed {
+			t.Fatalf("job %d at %d has index %d, removed %v", j.handle, i, j.index, j.removed)
+		}
+		if i > 0 && j.before(s.jobs[(i-1)/2]) {
+			t.Fatalf("job %d at %d runs before its parent", j.handle, i)
+		}
+	}
+}
+
+func TestSchedule_heap(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	r := rand.New(rand.NewSource(1))
+	var handles []Handle
+	for i := 0; i < 200; i++ {
+		handles = append(handles, s.AddFunc(time.Duration(r.Intn(600)+1)*time.Second, false, nil))
+	}
+	checkHeap(t, s)
+	for _, i := range r.Perm(len(handles))[:100] {
+		s.RemoveJob(handles[i])
+	}
+	for _, i := range r.Perm(len(handles))[:50] {
+		s.Pause(handles[i])
+		s.Reschedule(handles[i], time.Duration(r.Intn(600)+1)*time.Second)
+	}
+	checkHeap(t, s)
+	for i := 0; i < 20; i++ {
+		fc.Advance(time.Second * 30)
+		serviceDue(s)
+		checkHeap(t, s)
+	}
+	infos := s.Jobs()
+	if len(infos) != 100 {
+		t.Fatalf("%d jobs left, want 100", len(infos))
+	}
+	for i := 1; i < len(infos); i++ {
+		if !infos[i].Paused && infos[i].NextRun.Before(infos[i-1].NextRun) {
+			t.Errorf("Jobs out of order at %d", i)
+		}
+	}
+}
+
+// Jobs added, removed and changed from other goroutines and from inside callbacks while Run services them
+func TestSchedule_concurrentModification(t *testing.T) {
+	s := NewSchedule(WithWorkers(8))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			var handles []Handle
+			for i := 0; i < 200; i++ {
+				switch n := len(handles); {
+				case n == 0 || r.Intn(3) == 0:
+					self := make(chan Handle, 1)
+					removeSelf := r.Intn(4) == 0
+					h := s.AddFunc(time.Millisecond*time.Duration(r.Intn(5)+1), true, func(context.Context) error {
+						// a job removing itself while it executes
+						select {
+						case h := <-self:
+							s.RemoveJob(h)
+						default:
+						}
+						return nil
+					})
+					if removeSelf {
+						self <- h
+					}
+					handles = append(handles, h)
+				case r.Intn(2) == 0:
+					i := r.Intn(n)
+					s.RemoveJob(handles[i])
+					handles = append(handles[:i], handles[i+1:]...)
+				default:
+					h := handles[r.Intn(n)]
+					s.Pause(h)
+					s.RunNow(h)
+					s.Resume(h)
+					s.Jobs()
+				}
+				if r.Intn(10) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	checkHeap(t, s)
+}
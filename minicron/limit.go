@@ -0,0 +1,87 @@
+package minicron
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RateLimit restricts how often scheduled runs of the jobs in a rate group (see WithRateGroup) may start.  Runs
+// which would exceed it are delayed, not dropped.  Runs started by RunNow or by a trigger are not delayed but do
+// count against the limit.
+type RateLimit struct {
+	Spacing time.Duration // minimum time between the start of runs in the group
+	Every   time.Duration // a token bucket refilled with one run every Every, no limit if zero
+	Burst   int           // size of the token bucket, at least 1
+}
+
+type limiter struct {
+	RateLimit
+	tokens  float64
+	filled  time.Time // when tokens was last brought up to date
+	lastRun time.Time
+}
+
+// Return how long a run must wait to keep within the limit, zero if it may start at now
+func (l *limiter) wait(now time.Time) time.Duration {
+	var wait time.Duration
+	if l.Spacing > 0 && !l.lastRun.IsZero() {
+		wait = l.lastRun.Add(l.Spacing).Sub(now)
+	}
+	if l.Every > 0 {
+		l.refill(now)
+		if l.tokens < 1 {
+			if d := time.Duration(math.Ceil((1 - l.tokens) * float64(l.Every))); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// Record a run starting at now
+func (l *limiter) take(now time.Time) {
+	if l.Every > 0 {
+		l.refill(now)
+		l.tokens--
+	}
+	l.lastRun = now
+}
+
+func (l *limiter) refill(now time.Time) {
+	burst := float64(l.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if l.filled.IsZero() {
+		l.tokens = burst
+	} else if now.After(l.filled) {
+		l.tokens = math.Min(burst, l.tokens+float64(now.Sub(l.filled))/float64(l.Every))
+	}
+	l.filled = now
+}
+
+// Return the time the job will actually be serviced, its next scheduled run plus any jitter or rate limit delay
+func (j *Job) due() time.Time {
+	return j.nextRun.Add(j.offset)
+}
+
+// Pick a new random delay for the job's next run
+func (j *Job) rejitter() {
+	j.offset = 0
+	if j.jitter > 0 {
+		j.offset = time.Duration(rand.Int63n(int64(j.jitter)))
+	}
+}
+
+// Return the rate limiter of the job's group, nil if it has none
+// mutex MUST be locked when running this
+func (sched *Schedule) limiter(j *Job) *limiter {
+	if len(j.group) == 0 {
+		return nil
+	}
+	return sched.limits[j.group]
+}
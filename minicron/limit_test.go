@@ -0,0 +1,101 @@
+package minicron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_jitter(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	var handles []Handle
+	for i := 0; i < 20; i++ {
+		handles = append(handles, s.AddFunc(time.Minute, false, nil, WithJitter(time.Second*30)))
+	}
+	offsets := make(map[time.Time]bool)
+	for _, h := range handles {
+		info, _ := s.Job(h)
+		if info.NextRun.Before(t0.Add(time.Minute)) || !info.NextRun.Before(t0.Add(time.Second*90)) {
+			t.Errorf("jittered next run %v", info.NextRun)
+		}
+		offsets[info.NextRun] = true
+	}
+	if len(offsets) < 2 {
+		t.Errorf("all jobs jittered by the same amount")
+	}
+	fc.Advance(time.Second * 90)
+	serviceDue(s)
+	for _, h := range handles {
+		// jitter does not move the job off its schedule
+		if info, _ := s.Job(h); info.Stats.Runs != 1 || info.NextRun.Before(t0.Add(time.Minute*2)) {
+			t.Errorf("after jittered run %+v", info)
+		}
+	}
+}
+
+func TestSchedule_rateSpacing(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc), WithRateLimit("rapidapi", RateLimit{Spacing: time.Second * 10}))
+	a := s.AddFunc(time.Minute, false, nil, WithRateGroup("rapidapi"))
+	b := s.AddFunc(time.Minute, false, nil, WithRateGroup("rapidapi"))
+	other := s.AddFunc(time.Minute, false, nil)
+	fc.Advance(time.Minute)
+	serviceDue(s)
+	if info, _ := s.Job(a); info.Stats.Runs != 1 {
+		t.Errorf("first job in group did not run %+v", info)
+	}
+	if info, _ := s.Job(other); info.Stats.Runs != 1 {
+		t.Errorf("job outside group was delayed %+v", info)
+	}
+	if info, _ := s.Job(b); info.Stats.Runs != 0 || !info.NextRun.Equal(t0.Add(time.Second*70)) {
+		t.Errorf("second job in group not delayed %+v", info)
+	}
+	fc.Advance(time.Second * 10)
+	serviceDue(s)
+	// the delayed job stays aligned to its schedule
+	if info, _ := s.Job(b); info.Stats.Runs != 1 || !info.NextRun.Equal(t0.Add(time.Minute*2)) {
+		t.Errorf("delayed job %+v", info)
+	}
+}
+
+func TestSchedule_rateBucket(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc), WithRateLimit("rapidapi", RateLimit{Every: time.Minute, Burst: 2}))
+	var handles []Handle
+	for i := 0; i < 3; i++ {
+		handles = append(handles, s.AddFunc(time.Hour, true, nil, WithRateGroup("rapidapi")))
+	}
+	serviceDue(s)
+	runs := func() (n uint64) {
+		for _, h := range handles {
+			info, _ := s.Job(h)
+			n += info.Stats.Runs
+		}
+		return n
+	}
+	if n := runs(); n != 2 {
+		t.Fatalf("burst of 2 allowed %d runs", n)
+	}
+	fc.Advance(time.Second * 59)
+	serviceDue(s)
+	if n := runs(); n != 2 {
+		t.Errorf("run allowed before bucket refilled")
+	}
+	fc.Advance(time.Second)
+	serviceDue(s)
+	if n := runs(); n != 3 {
+		t.Errorf("run not allowed after bucket refilled")
+	}
+	// RunNow is not delayed even though the bucket is empty, and leaves it a further run short
+	s.RunNow(handles[0])
+	s.running.Wait()
+	if info, _ := s.Job(handles[1]); info.Stats.Runs != 1 {
+		t.Fatalf("unexpected runs %+v", info)
+	}
+	s.mutex.Lock()
+	wait := s.limits["rapidapi"].wait(fc.Now())
+	s.mutex.Unlock()
+	if wait != time.Minute*2 {
+		t.Errorf("after RunNow wait %v", wait)
+	}
+}
@@ -0,0 +1,114 @@
+package minicron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func newYork(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// Collect the successive times a spec matches from start
+func cronTimes(t *testing.T, spec string, start time.Time, n int) []time.Time {
+	cs, err := ParseCron(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Time
+	for at := start; len(times) < n; {
+		at = cs.Next(at)
+		times = append(times, at)
+	}
+	return times
+}
+
+func checkTimes(t *testing.T, spec string, got, want []time.Time) {
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("%q run %d at %v, want %v", spec, i, got[i], want[i])
+		}
+	}
+}
+
+// 2021-03-14 in New York the clock goes from 01:59:59 EST to 03:00:00 EDT
+func TestCronSpec_Next_springForward(t *testing.T) {
+	ny := newYork(t)
+	start := time.Date(2021, 3, 13, 6, 0, 0, 0, ny)
+	checkTimes(t, "0 7 * * *", cronTimes(t, "0 7 * * *", start, 3), []time.Time{
+		time.Date(2021, 3, 13, 7, 0, 0, 0, ny),
+		time.Date(2021, 3, 14, 7, 0, 0, 0, ny),
+		time.Date(2021, 3, 15, 7, 0, 0, 0, ny),
+	})
+	if d := time.Date(2021, 3, 14, 7, 0, 0, 0, ny).Sub(time.Date(2021, 3, 13, 7, 0, 0, 0, ny)); d != time.Hour*23 {
+		t.Fatalf("test location has no DST transition, day was %v", d)
+	}
+	// skipped time runs once as soon as the clock jumps
+	checkTimes(t, "30 2 * * *", cronTimes(t, "30 2 * * *", start, 3), []time.Time{
+		time.Date(2021, 3, 14, 3, 0, 0, 0, ny),
+		time.Date(2021, 3, 15, 2, 30, 0, 0, ny),
+		time.Date(2021, 3, 16, 2, 30, 0, 0, ny),
+	})
+	// wildcard hour follows elapsed time
+	checkTimes(t, "30 * * * *", cronTimes(t, "30 * * * *", time.Date(2021, 3, 14, 1, 0, 0, 0, ny), 2), []time.Time{
+		time.Date(2021, 3, 14, 1, 30, 0, 0, ny),
+		time.Date(2021, 3, 14, 3, 30, 0, 0, ny),
+	})
+}
+
+// 2021-11-07 in New York the clock goes from 01:59:59 EDT back to 01:00:00 EST
+func TestCronSpec_Next_fallBack(t *testing.T) {
+	ny := newYork(t)
+	est := time.FixedZone("EST", -5*3600)
+	edt := time.FixedZone("EDT", -4*3600)
+	start := time.Date(2021, 11, 6, 6, 0, 0, 0, ny)
+	checkTimes(t, "0 7 * * *", cronTimes(t, "0 7 * * *", start, 3), []time.Time{
+		time.Date(2021, 11, 6, 7, 0, 0, 0, ny),
+		time.Date(2021, 11, 7, 7, 0, 0, 0, ny),
+		time.Date(2021, 11, 8, 7, 0, 0, 0, ny),
+	})
+	// repeated time only runs once
+	checkTimes(t, "30 1 * * *", cronTimes(t, "30 1 * * *", start, 2), []time.Time{
+		time.Date(2021, 11, 7, 1, 30, 0, 0, edt),
+		time.Date(2021, 11, 8, 1, 30, 0, 0, est),
+	})
+	// wildcard hour runs through the repeated hour
+	checkTimes(t, "*/30 * * * *", cronTimes(t, "*/30 * * * *", time.Date(2021, 11, 7, 0, 59, 0, 0, ny), 5), []time.Time{
+		time.Date(2021, 11, 7, 1, 0, 0, 0, edt),
+		time.Date(2021, 11, 7, 1, 30, 0, 0, edt),
+		time.Date(2021, 11, 7, 1, 0, 0, 0, est),
+		time.Date(2021, 11, 7, 1, 30, 0, 0, est),
+		time.Date(2021, 11, 7, 2, 0, 0, 0, est),
+	})
+}
+
+func TestSchedule_location(t *testing.T) {
+	ny := newYork(t)
+	// The schedule's clock runs in UTC but jobs follow New York wall clock time
+	fc := NewFakeClock(time.Date(2021, 3, 13, 12, 0, 0, 0, time.UTC))
+	s := NewSchedule(WithClock(fc), WithLocation(ny))
+	h, err := s.AddCronFunc("0 7 * * *", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hUTC, _ := s.AddCronFunc("0 7 * * *", nil, InLocation(time.UTC))
+	for _, want := range []time.Time{
+		time.Date(2021, 3, 14, 11, 0, 0, 0, time.UTC), // 07:00 EDT
+		time.Date(2021, 3, 15, 11, 0, 0, 0, time.UTC),
+	} {
+		info, _ := s.Job(h)
+		if !info.NextRun.Equal(want) {
+			t.Errorf("next run %v, want %v", info.NextRun, want)
+		}
+		fc.Set(want)
+		serviceDue(s)
+	}
+	if info, _ := s.Job(hUTC); !info.NextRun.Equal(time.Date(2021, 3, 16, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("job with own location next run %v", info.NextRun)
+	}
+}
@@ -0,0 +1,182 @@
+package minicron
+
+import (
+	"fmt"
+	"time"
+)
+
+// JobInfo is a snapshot of a job's configuration and state
+type JobInfo struct {
+	Handle   Handle
+	Name     string
+	Tags     []string
+	Interval time.Duration // zero for cron jobs
+	Cron     string        // cron expression, empty for interval jobs
+	Once     bool          // the job is removed after its next run, see Schedule.AddOnce
+	LastRun  time.Time
+	NextRun  time.Time
+	Paused   bool
+	After    [][]Handle // jobs which trigger this one, see Schedule.After
+	Stats    JobStats
+}
+
+// mutex MUST be locked when running this
+func (j *Job) info() JobInfo {
+	info := JobInfo{
+		Handle:   j.handle,
+		Name:     j.name,
+		Tags:     append([]string(nil), j.tags...),
+		Interval: j.Interval,
+		LastRun:  j.lastRun,
+		NextRun:  j.due(),
+		Paused:   j.paused,
+		Once:     j.once,
+		Stats:    j.currentStats(),
+	}
+	for _, t := range j.after {
+		info.After = append(info.After, append([]Handle(nil), t.deps...))
+	}
+	if j.cron != nil {
+		info.Cron = j.cron.String()
+	}
+	return info
+}
+
+func (j *Job) hasTag(tag string) bool {
+	for _, t := range j.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Jobs returns all jobs in the order they will next run.  If tags are given only jobs having at least one of
+// them are returned.
+func (sched *Schedule) Jobs(tags ...string) []JobInfo {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	infos := make([]JobInfo, 0, len(sched.jobs))
+	for _, j := range sched.sorted() {
+		match := len(tags) == 0
+		for _, tag := range tags {
+			match = match || j.hasTag(tag)
+		}
+		if match {
+			infos = append(infos, j.info())
+		}
+	}
+	return infos
+}
+
+// Job returns a snapshot of a single job.
+func (sched *Schedule) Job(handle Handle) (JobInfo, bool) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	j := sched.find(handle)
+	if j == nil {
+		return JobInfo{}, false
+	}
+	return j.info(), true
+}
+
+// Lookup returns the handle of the job with the given name.
+func (sched *Schedule) Lookup(name string) (Handle, bool) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	for _, j := range sched.jobs {
+		if j.name == name {
+			return j.handle, true
+		}
+	}
+	return 0, false
+}
+
+// Find a job by handle and run f on it, keeping the schedule ordered and Run informed of any change
+func (sched *Schedule) modify(handle Handle, f func(j *Job) error) error {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	j := sched.find(handle)
+	if j == nil {
+		return fmt.Errorf("job %d not found", handle)
+	}
+	if err := f(j); err != nil {
+		return err
+	}
+	sched.fix(j)
+	return nil
+}
+
+// Pause stops a job being run by the schedule until Resume is called.  A run already executing is not affected.
+func (sched *Schedule) Pause(handle Handle) error {
+	return sched.modify(handle, func(j *Job) error {
+		j.paused = true
+		j.pending = 0
+		return nil
+	})
+}
+
+// Resume restarts a paused job.  Runs which fell due while it was paused are not made up; the job next runs at
+// its first scheduled time after now.
+func (sched *Schedule) Resume(handle Handle) error {
+	return sched.modify(handle, func(j *Job) error {
+		if !j.paused {
+			return nil
+		}
+		j.paused = false
+		if j.triggered {
+			return nil
+		}
+		if !j.regularRun.IsZero() {
+			// drop a pending retry and go back to the regular schedule
+			j.nextRun = j.regularRun
+			j.regularRun = time.Time{}
+		}
+		now := sched.clock.Now()
+		if j.cron != nil {
+			if j.nextRun.Before(now) {
+				j.nextRun = j.cronNext(now)
+			}
+		} else if !j.once {
+			for j.nextRun.Before(now) {
+				j.nextRun = j.nextRun.Add(j.Interval)
+			}
+		}
+		return nil
+	})
+}
+
+// Reschedule changes a job to run every interval, measured from its last run.  A cron job becomes an interval
+// job, and a triggered or one-shot job gains a schedule of its own; a one-shot job still first runs at its time.
+func (sched *Schedule) Reschedule(handle Handle, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	return sched.modify(handle, func(j *Job) error {
+		once := j.once
+		j.Interval = interval
+		j.cron = nil
+		j.once = false
+		j.triggered = false
+		j.regularRun = time.Time{}
+		if once {
+			// nextRun is still the one-shot's time, which anchors the new schedule
+			return nil
+		}
+		if j.lastRun.IsZero() {
+			j.lastRun = sched.clock.Now()
+		}
+		j.calcNextRun()
+		return nil
+	})
+}
+
+// RunNow starts a job immediately, subject to its overlap policy, without changing when it next runs.  Paused
+// jobs can be run this way.
+func (sched *Schedule) RunNow(handle Handle) error {
+	return sched.modify(handle, func(j *Job) error {
+		j.lastRun = sched.clock.Now()
+		sched.dispatch(j)
+		return nil
+	})
+}
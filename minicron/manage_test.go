@@ -0,0 +1,141 @@
+package minicron
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Start every due job and wait for them to finish, for tests not using Run
+func serviceDue(s *Schedule) {
+	s.mutex.Lock()
+	for s.serviceJob(s.clock.Now()) {
+	}
+	s.mutex.Unlock()
+	s.running.Wait()
+}
+
+func TestSchedule_Jobs(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	hCal := s.AddFunc(time.Minute*5, false, nil, WithName("calendar"), WithTags("graph", "fetch"))
+	hMkt := s.AddFunc(time.Minute, false, nil, WithName("market"), WithTags("rapidapi", "fetch"))
+	hCron, _ := s.AddCronFunc("*/30 * * * * *", nil, WithName("render"))
+	infos := s.Jobs()
+	if len(infos) != 3 || infos[0].Handle != hCron || infos[1].Handle != hMkt || infos[2].Handle != hCal {
+		t.Fatalf("unexpected jobs %+v", infos)
+	}
+	if infos[0].Cron != "*/30 * * * * *" || infos[0].Interval != 0 || !infos[0].NextRun.Equal(t0.Add(time.Second*30)) {
+		t.Errorf("unexpected cron job info %+v", infos[0])
+	}
+	if infos[2].Name != "calendar" || infos[2].Interval != time.Minute*5 || len(infos[2].Tags) != 2 {
+		t.Errorf("unexpected interval job info %+v", infos[2])
+	}
+	if fetch := s.Jobs("fetch"); len(fetch) != 2 {
+		t.Errorf("expected 2 jobs tagged fetch, got %d", len(fetch))
+	}
+	if h, ok := s.Lookup("market"); !ok || h != hMkt {
+		t.Errorf("Lookup(market) = %d, %v", h, ok)
+	}
+	if _, ok := s.Lookup("nothing"); ok {
+		t.Errorf("Lookup of unknown name succeeded")
+	}
+}
+
+func TestSchedule_PauseResume(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	h := s.AddFunc(time.Minute, false, nil)
+	other := s.AddFunc(time.Minute*2, false, nil)
+	if err := s.Pause(h); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(time.Minute * 4)
+	serviceDue(s)
+	if info, _ := s.Job(h); info.Stats.Runs != 0 || !info.Paused {
+		t.Errorf("paused job ran %+v", info)
+	}
+	if info, _ := s.Job(other); info.Stats.Runs != 1 {
+		t.Errorf("job behind a paused job did not run %+v", info)
+	}
+	fc.Advance(time.Second * 30)
+	s.Resume(h)
+	// missed runs are not made up
+	if info, _ := s.Job(h); info.Paused || !info.NextRun.Equal(t0.Add(time.Minute*5)) {
+		t.Errorf("resumed job %+v", info)
+	}
+	serviceDue(s)
+	fc.Advance(time.Second * 30)
+	serviceDue(s)
+	if info, _ := s.Job(h); info.Stats.Runs != 1 {
+		t.Errorf("resumed job did not run %+v", info)
+	}
+	if err := s.Pause(999); err == nil {
+		t.Errorf("Pause of unknown job succeeded")
+	}
+
+	// pausing while a retry is pending goes back to the regular schedule on resume
+	fc.Set(t0)
+	s = NewSchedule(WithClock(fc))
+	h = s.AddFunc(time.Minute*5, true, func(ctx context.Context) error {
+		return errors.New("unavailable")
+	}, WithRetry(Backoff{Initial: time.Second * 15}))
+	serviceDue(s)
+	if info, _ := s.Job(h); !info.NextRun.Equal(t0.Add(time.Second * 15)) {
+		t.Fatalf("retry at %v", info.NextRun)
+	}
+	fc.Set(t0.Add(time.Second * 20))
+	s.Pause(h)
+	s.Resume(h)
+	if info, _ := s.Job(h); !info.NextRun.Equal(t0.Add(time.Minute * 5)) {
+		t.Errorf("resumed job next runs at %v, want %v", info.NextRun, t0.Add(time.Minute*5))
+	}
+}
+
+func TestSchedule_Reschedule(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	h, _ := s.AddCronFunc("0 * * * *", nil)
+	if err := s.Reschedule(h, time.Minute*10); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := s.Job(h)
+	if info.Cron != "" || info.Interval != time.Minute*10 || !info.NextRun.Equal(t0.Add(time.Minute*10)) {
+		t.Errorf("rescheduled job %+v", info)
+	}
+	if err := s.Reschedule(h, 0); err == nil {
+		t.Errorf("Reschedule to zero interval succeeded")
+	}
+
+	// a one-shot which has not run yet keeps its time and then repeats from it
+	h = s.AddOnce(t0.Add(time.Hour), nil)
+	if err := s.Reschedule(h, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := s.Job(h); info.Once || !info.NextRun.Equal(t0.Add(time.Hour)) {
+		t.Errorf("rescheduled one-shot %+v", info)
+	}
+	fc.Set(t0.Add(time.Hour))
+	serviceDue(s)
+	info, ok := s.Job(h)
+	if !ok || info.Stats.Runs != 1 || info.Stats.Missed != 0 || !info.NextRun.Equal(t0.Add(time.Hour+time.Minute)) {
+		t.Errorf("rescheduled one-shot after running %+v", info)
+	}
+}
+
+func TestSchedule_RunNow(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	runs := 0
+	h := s.AddJob(time.Minute*5, false, func(...interface{}) { runs++ })
+	fc.Advance(time.Minute)
+	if err := s.RunNow(h); err != nil {
+		t.Fatal(err)
+	}
+	s.running.Wait()
+	info, _ := s.Job(h)
+	if runs != 1 || !info.LastRun.Equal(t0.Add(time.Minute)) || !info.NextRun.Equal(t0.Add(time.Minute*5)) {
+		t.Errorf("after RunNow runs=%d %+v", runs, info)
+	}
+}
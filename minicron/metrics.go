@@ -0,0 +1,82 @@
+package minicron
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// Hooks are functions called around every job run, see WithHooks.  They are called on the job's worker
+// goroutine, so must be safe for concurrent use, and may call Schedule methods.
+type Hooks struct {
+	// Before is called just before the job's callback
+	Before func(info JobInfo)
+	// After is called when the callback returns or panics
+	After func(info JobInfo, err error, elapsed time.Duration)
+	// Panic is called when the callback panics, After is still called with a *PanicError
+	Panic func(info JobInfo, recovered interface{}, stack []byte)
+}
+
+// PanicError is the error recorded for a run whose callback panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("job panicked: %v", e.Value)
+}
+
+// Number of recent run durations kept per job for percentiles
+const latencySamples = 128
+
+// latencies is a ring buffer of recent run durations
+type latencies struct {
+	samples [latencySamples]time.Duration
+	n       int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.samples[l.n%latencySamples] = d
+	l.n++
+}
+
+func (l *latencies) percentiles() (p50, p99 time.Duration) {
+	n := l.n
+	if n == 0 {
+		return 0, 0
+	}
+	if n > latencySamples {
+		n = latencySamples
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(n-1)*50/100], sorted[(n-1)*99/100]
+}
+
+// Snapshot the job for hooks, skipped when there are none
+func (sched *Schedule) hookInfo(j *Job) JobInfo {
+	if sched.hooks.Before == nil && sched.hooks.After == nil && sched.hooks.Panic == nil {
+		return JobInfo{}
+	}
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	return j.info()
+}
+
+// Run the callback, turning a panic into a *PanicError so it cannot take down the program
+func (sched *Schedule) call(ctx context.Context, info JobInfo, fn JobFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			if sched.hooks.Panic != nil {
+				sched.hooks.Panic(info, r, stack)
+			}
+			err = &PanicError{Value: r, Stack: stack}
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,100 @@
+package minicron
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedule_hooks(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		events []string
+	)
+	record := func(ev string) {
+		mutex.Lock()
+		events = append(events, ev)
+		mutex.Unlock()
+	}
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc), WithHooks(Hooks{
+		Before: func(info JobInfo) { record("before " + info.Name) },
+		After: func(info JobInfo, err error, elapsed time.Duration) {
+			record("after " + info.Name + " " + elapsed.String())
+			if info.Name == "panics" {
+				if _, ok := err.(*PanicError); !ok {
+					t.Errorf("After hook given %v for panic", err)
+				}
+			}
+		},
+		Panic: func(info JobInfo, recovered interface{}, stack []byte) {
+			record("panic " + info.Name + " " + recovered.(string))
+			if len(stack) == 0 {
+				t.Errorf("no stack trace for panic")
+			}
+		},
+	}))
+	h := s.AddFunc(time.Minute, true, func(context.Context) error {
+		fc.Advance(time.Second)
+		return nil
+	}, WithName("ok"))
+	serviceDue(s)
+	hPanic := s.AddFunc(time.Minute, true, func(context.Context) error {
+		panic("boom")
+	}, WithName("panics"))
+	serviceDue(s)
+	want := []string{"before ok", "after ok 1s", "before panics", "panic panics boom", "after panics 0s"}
+	if len(events) != len(want) {
+		t.Fatalf("hook events %q, want %q", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("hook event %d %q, want %q", i, events[i], want[i])
+		}
+	}
+	if stats, _ := s.Stats(h); stats.LastDuration != time.Second || stats.Failures != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats, _ := s.Stats(hPanic); stats.Failures != 1 || stats.Panics != 1 || stats.LastError == nil {
+		t.Errorf("panicking job stats %+v", stats)
+	}
+}
+
+func TestSchedule_latency(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	var d time.Duration
+	fail := errors.New("fail")
+	h := s.AddFunc(time.Hour, false, func(context.Context) error {
+		fc.Advance(d)
+		if d%(time.Millisecond*10) == 0 {
+			return fail
+		}
+		return nil
+	})
+	for i := 1; i <= 100; i++ {
+		d = time.Duration(i) * time.Millisecond
+		s.RunNow(h)
+		s.running.Wait()
+	}
+	stats, _ := s.Stats(h)
+	if stats.Runs != 100 || stats.Failures != 10 || stats.LastDuration != time.Millisecond*100 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats.P50 != time.Millisecond*50 || stats.P99 != time.Millisecond*99 {
+		t.Errorf("P50 %v P99 %v", stats.P50, stats.P99)
+	}
+}
+
+func TestLatencies_wrap(t *testing.T) {
+	var l latencies
+	for i := 0; i < latencySamples*3; i++ {
+		l.add(time.Duration(i))
+	}
+	// only the most recent samples count
+	if p50, _ := l.percentiles(); p50 < latencySamples*2 {
+		t.Errorf("percentile %v includes old samples", p50)
+	}
+}
@@ -1,7 +1,9 @@
 package minicron
 
 import (
-	"sort"
+	"container/heap"
+	"context"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -11,36 +13,171 @@ type Handle uint64
 
 type CallBack func(...interface{})
 
+// JobFunc is a job callback.  ctx is cancelled when the schedule stops running or the job's timeout expires.  A
+// non-nil error counts as a failure and, if the job has a Backoff, causes it to be retried.
+type JobFunc func(ctx context.Context) error
+
+// Adapt wraps a CallBack and its parameters as a JobFunc which always succeeds.
+func Adapt(cb CallBack, cbParams ...interface{}) JobFunc {
+	if cb == nil {
+		return nil
+	}
+	return func(context.Context) error {
+		cb(cbParams...)
+		return nil
+	}
+}
+
+// OverlapPolicy determines what happens when a job becomes due while a previous run of it is still executing.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip drops the new run (the default)
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue holds one run until the current one finishes; further runs are dropped
+	OverlapQueue
+	// OverlapAllow starts the new run in parallel with the current one
+	OverlapAllow
+)
+
+// DefaultWorkers is the number of job callbacks a Schedule will run concurrently unless WithWorkers is used.
+const DefaultWorkers = 4
+
 type Job struct {
 	handle           Handle
+	name             string
+	tags             []string
 	Interval         time.Duration
+	cron             *CronSpec
+	loc              *time.Location
 	lastRun, nextRun time.Time
-	callback         CallBack
-	cbParams         []interface{}
+	fn               JobFunc
+	overlap          OverlapPolicy
+	misfire          MisfirePolicy
+	timeout          time.Duration
+	retry            *Backoff
+	// retries made since the last regularly scheduled run, and the regular run time displaced by a retry
+	attempts   int
+	regularRun time.Time
+	running    int
+	pending    int // runs waiting for the current one to finish
+	paused     bool
+	removed    bool
+	finished   bool // out of runs and taken out of the schedule, but its last run may not have ended
+	// triggers from other jobs, see After.  A triggered job has no schedule of its own.
+	after      []*trigger
+	dependents []*Job // jobs with a trigger including this one
+	triggered  bool
+	jitter     time.Duration
+	offset     time.Duration // delay of the next run for jitter or rate limiting
+	group      string
+	window     *window
+	once       bool
+	index      int // position in the schedule's heap
+	stats      JobStats
+	latency    latencies
+}
+
+// JobStats are execution counters for a single job
+type JobStats struct {
+	Runs         uint64        // runs started
+	Skipped      uint64        // runs dropped by the job's OverlapPolicy or window
+	Failures     uint64        // runs which returned an error or panicked
+	Panics       uint64        // runs which panicked
+	Retries      uint64        // runs started early because of a Backoff
+	Missed       uint64        // scheduled runs which passed while the job was overdue, see MisfirePolicy
+	Running      int           // runs currently executing
+	LastError    error         // error returned by the most recent run to finish
+	LastDuration time.Duration // execution time of the most recent run to finish
+	P50, P99     time.Duration // execution time percentiles over recent runs
+}
+
+// Backoff configures retrying a job whose callback returns an error.  Retries are scheduled at exponentially
+// increasing delays but never later than the job's next regular run.  Initial must be positive.
+type Backoff struct {
+	Initial    time.Duration // delay before the first retry
+	Max        time.Duration // upper limit on the delay, no limit if zero
+	Multiplier float64       // growth of the delay per retry, 2 if zero
+	MaxRetries int           // retries per regular run, unlimited if zero
+}
+
+func (b *Backoff) delay(attempt int) time.Duration {
+	m := b.Multiplier
+	if m <= 0 {
+		m = 2
+	}
+	d := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+		d *= m
+		if b.Max > 0 && d >= float64(b.Max) {
+			return b.Max
+		}
+	}
+	return time.Duration(d)
 }
 
 type Schedule struct {
 	mutex      sync.Mutex
 	basetime   time.Time
-	jobs       []Job
+	jobs       jobHeap
+	byHandle   map[Handle]*Job
 	nextHandle uint64
+	clock      Clock
+	// ctx is the parent context of job callbacks, it is replaced by the context given to Run
+	ctx context.Context
+	// wake is signalled when the job at the head of the schedule changes so Run can recalculate its sleep
+	wake chan struct{}
+	// workers is a semaphore bounding the number of callbacks executing at once
+	workers   chan struct{}
+	running   sync.WaitGroup
+	misfires  []MisfireEvent
+	onMisfire func(MisfireEvent)
+	onError   func(error)
+	hooks     Hooks
+	store     StateStore
+	loc       *time.Location
+	limits    map[string]*limiter
 }
 
-func NewSchedule() Schedule {
-	return Schedule{
-		basetime: time.Now(),
+func NewSchedule(opts ...Option) *Schedule {
+	sched := &Schedule{
+		clock:    RealClock{},
+		byHandle: make(map[Handle]*Job),
+		ctx:      context.Background(),
+		wake:     make(chan struct{}, 1),
+		workers:  make(chan struct{}, DefaultWorkers),
 	}
+	for _, opt := range opts {
+		opt(sched)
+	}
+	sched.basetime = sched.clock.Now()
+	return sched
 }
 
 func (sched *Schedule) AddJob(duration time.Duration, initialRun bool, cb CallBack, cbParams ...interface{}) Handle {
+	return sched.AddFunc(duration, initialRun, Adapt(cb, cbParams...))
+}
+
+// AddFunc adds a job which runs every duration.  If initialRun is true the job is due immediately.  A duration
+// which is not positive, or invalid options, are refused: the error goes to the error handler (see
+// WithErrorHandler) and the zero Handle, which matches no job, is returned.
+func (sched *Schedule) AddFunc(duration time.Duration, initialRun bool, fn JobFunc, opts ...JobOption) Handle {
+	if duration <= 0 {
+		sched.reportError(fmt.Errorf("interval must be positive"))
+		return 0
+	}
+	if err := checkOptions(false, opts); err != nil {
+		sched.reportError(err)
+		return 0
+	}
 	sched.mutex.Lock()
-	j := Job{
+	j := &Job{
 		Interval: duration,
-		callback: cb,
-		cbParams: cbParams,
+		fn:       fn,
 		lastRun:  sched.basetime,
+		loc:      sched.loc,
 	}
-	now := time.Now()
+	now := sched.clock.Now()
 	// A schedule always has a fixed basetime from which all job next execution times are derived.  This
 	// ensures the natural order of jobs and eliminates run-time delays from affecting order.
 	// We need to increment the base time to calculate the next run for this new job so we loop until
@@ -57,54 +194,393 @@ func (sched *Schedule) AddJob(duration time.Duration, initialRun bool, cb CallBa
 		j.nextRun = j.nextRun.Add(-duration)
 		j.lastRun = j.lastRun.Add(-duration)
 	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	err := sched.restore(j)
 	sched.nextHandle++
 	j.handle = Handle(sched.nextHandle)
-	sched.jobs = append(sched.jobs, j)
-	sched.sortjobs()
+	sched.insert(j)
 	sched.mutex.Unlock()
+	if err != nil {
+		sched.reportError(err)
+	}
+	return j.handle
+}
+
+// AddOnce adds a job which runs once at the given time and is then removed from the schedule.  A time which has
+// already passed runs the job immediately.  Invalid options are refused as by AddFunc; a one-shot job cannot be
+// given WithRetry.
+func (sched *Schedule) AddOnce(at time.Time, fn JobFunc, opts ...JobOption) Handle {
+	if err := checkOptions(true, opts); err != nil {
+		sched.reportError(err)
+		return 0
+	}
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	j := &Job{
+		fn:      fn,
+		nextRun: at,
+		loc:     sched.loc,
+		once:    true,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	sched.nextHandle++
+	j.handle = Handle(sched.nextHandle)
+	sched.insert(j)
 	return j.handle
 }
 
-// Sort jobs by the next one which needs to run
+// AddCronJob adds a job which runs at the times matched by the cron expression spec (see ParseCron).
+func (sched *Schedule) AddCronJob(spec string, cb CallBack, cbParams ...interface{}) (Handle, error) {
+	return sched.AddCronFunc(spec, Adapt(cb, cbParams...))
+}
+
+// AddCronFunc adds a job which runs at the times matched by the cron expression spec (see ParseCron).
+func (sched *Schedule) AddCronFunc(spec string, fn JobFunc, opts ...JobOption) (Handle, error) {
+	cs, err := ParseCron(spec)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkOptions(false, opts); err != nil {
+		return 0, err
+	}
+	sched.mutex.Lock()
+	j := &Job{
+		cron:    cs,
+		fn:      fn,
+		lastRun: sched.clock.Now(),
+		loc:     sched.loc,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	j.calcNextRun()
+	if j.nextRun.IsZero() {
+		sched.mutex.Unlock()
+		return 0, fmt.Errorf("cron spec %q never matches", spec)
+	}
+	err = sched.restore(j)
+	sched.nextHandle++
+	j.handle = Handle(sched.nextHandle)
+	sched.insert(j)
+	sched.mutex.Unlock()
+	if err != nil {
+		sched.reportError(err)
+	}
+	return j.handle, nil
+}
+
+// Add a job to the schedule, waking Run if the new job is now the first to run
 // mutex MUST be locked when running this
-func (sched *Schedule) sortjobs() {
-	sort.Slice(sched.jobs, func(i int, j int) bool {
-		if sched.jobs[i].nextRun.Equal(sched.jobs[j].nextRun) {
-			return sched.jobs[i].handle < sched.jobs[j].handle
-		}
-		return sched.jobs[i].nextRun.Before(sched.jobs[j].nextRun)
-	})
+func (sched *Schedule) insert(j *Job) {
+	j.rejitter()
+	sched.byHandle[j.handle] = j
+	heap.Push(&sched.jobs, j)
+	if j.index == 0 {
+		sched.wakeup()
+	}
+}
+
+func (sched *Schedule) wakeup() {
+	select {
+	case sched.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Report whether the job is not run by the schedule's clock
+func (j *Job) idle() bool {
+	return j.paused || j.triggered
 }
 
+// Return the next time matching the job's cron spec after t, evaluated in the job's location
+func (j *Job) cronNext(t time.Time) time.Time {
+	if j.loc != nil {
+		t = t.In(j.loc)
+	}
+	return j.cron.Next(t)
+}
+
+// Calculate the next run time of the job from its last run
 func (j *Job) calcNextRun() {
+	if j.cron != nil {
+		j.nextRun = j.cronNext(j.lastRun)
+	} else {
+		j.nextRun = j.lastRun.Add(j.Interval)
+	}
+}
+
+// Check job options are valid before they are applied to a job
+func checkOptions(once bool, opts []JobOption) error {
+	var j Job
+	for _, opt := range opts {
+		opt(&j)
+	}
+	if j.retry != nil && j.retry.Initial <= 0 {
+		// a zero delay would retry a failing job in a tight loop
+		return fmt.Errorf("retry delay must be positive")
+	}
+	if j.retry != nil && once {
+		return fmt.Errorf("one-shot jobs cannot be retried")
+	}
+	return nil
+}
+
+// Find a job by handle
+// mutex MUST be locked when running this
+func (sched *Schedule) find(handle Handle) *Job {
+	return sched.byHandle[handle]
+}
+
+// SetOptions applies job options to an existing job.  If any option is invalid none are applied.
+func (sched *Schedule) SetOptions(handle Handle, opts ...JobOption) error {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	j := sched.find(handle)
+	if j == nil {
+		return fmt.Errorf("job %d not found", handle)
+	}
+	if err := checkOptions(j.once, opts); err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return nil
+}
+
+// Stats returns the execution counters of a job.
+func (sched *Schedule) Stats(handle Handle) (JobStats, bool) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	j := sched.find(handle)
+	if j == nil {
+		return JobStats{}, false
+	}
+	return j.currentStats(), true
+}
+
+// mutex MUST be locked when running this
+func (j *Job) currentStats() JobStats {
+	stats := j.stats
+	stats.Running = j.running
+	stats.P50, stats.P99 = j.latency.percentiles()
+	return stats
 }
 
+// RemoveJob removes a job from the schedule.  A run of the job already executing is allowed to finish.
 func (sched *Schedule) RemoveJob(handle Handle) {
 	sched.mutex.Lock()
-	for i := range sched.jobs {
-		if sched.jobs[i].handle == handle {
-			sched.jobs = append(sched.jobs[:i], sched.jobs[i+1:]...)
-			break
-		}
+	if j := sched.find(handle); j != nil {
+		sched.removeTriggers(j)
+		sched.unlink(j)
 	}
 	sched.mutex.Unlock()
 }
 
+// ServiceNextJob starts the first job in the schedule if it is due.
 func (sched *Schedule) ServiceNextJob() {
-	if len(sched.jobs) == 0 {
-		return
-	}
-	now := time.Now()
 	sched.mutex.Lock()
-	j := &sched.jobs[0]
-	if !now.Before(j.nextRun) {
-		sched.basetime = now
+	sched.serviceJob(sched.clock.Now())
+	sched.mutex.Unlock()
+	sched.reportMisfires()
+}
+
+// Run services jobs until ctx is cancelled.  It sleeps until the next job is due, starts every job which is due
+// and is woken early whenever AddJob or RemoveJob changes which job runs next.  Callbacks are passed a context
+// derived from ctx; once it is cancelled Run waits for executing callbacks to finish before returning.
+func (sched *Schedule) Run(ctx context.Context) {
+	sched.mutex.Lock()
+	sched.ctx = ctx
+	sched.mutex.Unlock()
+	timer := sched.clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		var timerC <-chan time.Time
+		sched.mutex.Lock()
+		for sched.serviceJob(sched.clock.Now()) {
+		}
+		if j := sched.head(); j != nil && !j.idle() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+			timer.Reset(j.due().Sub(sched.clock.Now()))
+			timerC = timer.C()
+		}
+		sched.mutex.Unlock()
+		sched.reportMisfires()
+		select {
+		case <-timerC:
+		case <-sched.wake:
+		case <-ctx.Done():
+			sched.running.Wait()
+			return
+		}
+	}
+}
+
+// Dispatch the first job if it is due, returns true if the job was due
+// mutex MUST be locked when running this
+func (sched *Schedule) serviceJob(now time.Time) bool {
+	j := sched.head()
+	if j == nil || j.idle() || now.Before(j.due()) {
+		return false
+	}
+	if l := sched.limiter(j); l != nil {
+		if wait := l.wait(now); wait > 0 {
+			j.offset = now.Add(wait).Sub(j.nextRun)
+			heap.Fix(&sched.jobs, j.index)
+			return true
+		}
+	}
+	run := true
+	if j.regularRun.IsZero() {
+		j.attempts = 0
+		run = sched.advance(j, now)
+	} else {
+		// this is a retry, keep the job on its regular cadence
+		j.nextRun = j.regularRun
+		j.regularRun = time.Time{}
+		j.stats.Retries++
+	}
+	if run && j.active(now) {
 		j.lastRun = now
-		j.nextRun = j.lastRun.Add(j.Interval)
-		if j.callback != nil {
-			j.callback(j.cbParams...)
+		sched.dispatch(j)
+	}
+	j.rejitter()
+	if j.nextRun.IsZero() {
+		// a one-shot job, or a cron job with no further matching times
+		sched.finish(j)
+	} else {
+		heap.Fix(&sched.jobs, j.index)
+	}
+	return true
+}
+
+// Start the job subject to its overlap policy
+// mutex MUST be locked when running this
+func (sched *Schedule) dispatch(j *Job) {
+	switch {
+	case j.running == 0 || j.overlap == OverlapAllow:
+		sched.start(j)
+	case j.overlap == OverlapQueue && j.pending == 0:
+		j.pending = 1
+	default:
+		j.stats.Skipped++
+	}
+}
+
+// Start a run of the job on a worker
+// mutex MUST be locked when running this
+func (sched *Schedule) start(j *Job) {
+	if l := sched.limiter(j); l != nil {
+		l.take(sched.clock.Now())
+	}
+	j.running++
+	j.stats.Runs++
+	sched.running.Add(1)
+	go sched.execute(sched.ctx, j, j.fn, j.timeout, sched.clock.Now())
+}
+
+func (sched *Schedule) execute(ctx context.Context, j *Job, fn JobFunc, timeout time.Duration, started time.Time) {
+	var err error
+	defer sched.running.Done()
+	if sched.workers != nil {
+		sched.workers <- struct{}{}
+	}
+	info := sched.hookInfo(j)
+	if sched.hooks.Before != nil {
+		sched.hooks.Before(info)
+	}
+	begin := sched.clock.Now()
+	if fn != nil {
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, timeout)
 		}
-		sched.sortjobs()
+		err = sched.call(runCtx, info, fn)
+		cancel()
 	}
+	elapsed := sched.clock.Now().Sub(begin)
+	if sched.workers != nil {
+		<-sched.workers
+	}
+	if sched.hooks.After != nil {
+		sched.hooks.After(info, err, elapsed)
+	}
+	sched.mutex.Lock()
+	j.running--
+	j.stats.LastError = err
+	j.stats.LastDuration = elapsed
+	j.latency.add(elapsed)
+	if err != nil {
+		j.stats.Failures++
+		if _, ok := err.(*PanicError); ok {
+			j.stats.Panics++
+		}
+		sched.scheduleRetry(ctx, j)
+	} else if !j.removed {
+		sched.fireTriggers(j)
+	}
+	if j.pending > 0 && j.running == 0 {
+		j.pending--
+		if !j.removed {
+			sched.start(j)
+		}
+	}
+	if j.finished && j.running == 0 {
+		sched.removeTriggers(j)
+	}
+	name := j.name
 	sched.mutex.Unlock()
+	if sched.store != nil && len(name) > 0 {
+		sched.saveState(name, started, err)
+	}
+}
+
+// Take a job with no further runs out of the schedule.  Unlike a job removed with RemoveJob, a run it has started
+// still fires the jobs depending on it, so its triggers are only removed once that run ends.
+// mutex MUST be locked when running this
+func (sched *Schedule) finish(j *Job) {
+	sched.detach(j)
+	j.finished = true
+	if j.running == 0 && j.pending == 0 {
+		sched.removeTriggers(j)
+	}
+}
+
+// Pass an error which has no caller to return to to the error handler
+func (sched *Schedule) reportError(err error) {
+	if sched.onError != nil {
+		sched.onError(err)
+	}
+}
+
+// Move the job's next run forward according to its Backoff after a failure
+// mutex MUST be locked when running this
+func (sched *Schedule) scheduleRetry(ctx context.Context, j *Job) {
+	if j.retry == nil || j.removed || ctx.Err() != nil {
+		return
+	}
+	if j.retry.MaxRetries > 0 && j.attempts >= j.retry.MaxRetries {
+		return
+	}
+	if !j.regularRun.IsZero() {
+		// a retry is already pending, e.g. from an overlapping run
+		return
+	}
+	j.attempts++
+	at := sched.clock.Now().Add(j.retry.delay(j.attempts))
+	if !at.Before(j.nextRun) {
+		return
+	}
+	j.regularRun = j.nextRun
+	j.nextRun = at
+	sched.fix(j)
 }
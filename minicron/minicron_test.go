@@ -1,24 +1,251 @@
 package minicron
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func TestSchedule_sortjobs(t *testing.T) {
+func TestSchedule_order(t *testing.T) {
 	s := NewSchedule()
 	s.AddJob(time.Minute, false, nil)
 	s.AddJob(time.Second*5, false, nil)
 	s.AddJob(time.Minute, false, nil)
 	s.AddJob(time.Minute, true, nil)
 	s.AddJob(time.Second*5, false, nil)
-	t.Run("sort1", func(t *testing.T) {
-		s.sortjobs()
-	})
 	correctOrder := []Handle{4, 2, 5, 1, 3}
-	for i := range s.jobs {
-		if s.jobs[i].handle != correctOrder[i] {
-			t.Errorf("sortjobs failed")
+	for i, info := range s.Jobs() {
+		if info.Handle != correctOrder[i] {
+			t.Errorf("job %d is %d, want %d", i, info.Handle, correctOrder[i])
 		}
 	}
+	if s.head().handle != correctOrder[0] {
+		t.Errorf("head of heap is %d", s.head().handle)
+	}
+}
+
+func TestSchedule_badInterval(t *testing.T) {
+	var reported error
+	s := NewSchedule(WithErrorHandler(func(err error) { reported = err }))
+	if h := s.AddFunc(0, true, nil); h != 0 || reported == nil {
+		t.Errorf("zero interval gave handle %d, error %v", h, reported)
+	}
+	if h := AddTyped(s, -time.Minute, false, func(ctx context.Context, v int) error { return nil }, 1); h != 0 {
+		t.Errorf("negative interval gave handle %d", h)
+	}
+	if jobs := s.Jobs(); len(jobs) != 0 {
+		t.Errorf("%d jobs added", len(jobs))
+	}
+}
+
+func TestSchedule_Run(t *testing.T) {
+	s := NewSchedule()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	// Run must be woken by AddJob even though it started with no jobs to sleep on
+	fast := make(chan time.Time, 10)
+	s.AddJob(time.Hour, true, func(...interface{}) { fast <- time.Now() })
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		t.Fatal("initial run of job added to idle schedule did not happen")
+	}
+	// Both due jobs must be serviced on the same wakeup
+	var count int32
+	s.AddJob(time.Millisecond*20, false, func(...interface{}) { atomic.AddInt32(&count, 1) })
+	s.AddJob(time.Millisecond*20, false, func(...interface{}) { atomic.AddInt32(&count, 1) })
+	time.Sleep(time.Millisecond * 110)
+	if n := atomic.LoadInt32(&count); n < 6 {
+		t.Errorf("expected at least 6 runs, got %d", n)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestSchedule_overlap(t *testing.T) {
+	fc := NewFakeClock(time.Now())
+	s := NewSchedule(WithWorkers(8), WithClock(fc))
+	release := make(chan struct{})
+	started := make(chan Handle, 20)
+	blocking := func(params ...interface{}) {
+		started <- params[0].(Handle)
+		<-release
+	}
+	// Handles are allocated sequentially so each job can be passed its own handle
+	hSkip := s.AddJob(time.Second, true, blocking, Handle(1))
+	hQueue := s.AddJob(time.Second, true, blocking, Handle(2))
+	hAllow := s.AddJob(time.Second, true, blocking, Handle(3))
+	s.SetOptions(hQueue, WithOverlap(OverlapQueue))
+	s.SetOptions(hAllow, WithOverlap(OverlapAllow))
+	if err := s.SetOptions(99, WithOverlap(OverlapAllow)); err == nil {
+		t.Errorf("SetOptions on unknown handle should fail")
+	}
+	// Three ticks while every first run is blocked
+	for i := 0; i < 3; i++ {
+		s.mutex.Lock()
+		for s.serviceJob(fc.Now()) {
+		}
+		s.mutex.Unlock()
+		fc.Advance(time.Second)
+	}
+	want := map[Handle]JobStats{
+		hSkip:  {Runs: 1, Skipped: 2, Running: 1},
+		hQueue: {Runs: 1, Skipped: 1, Running: 1},
+		hAllow: {Runs: 3, Skipped: 0, Running: 3},
+	}
+	for h, w := range want {
+		if got, _ := s.Stats(h); got != w {
+			t.Errorf("job %d stats %+v, want %+v", h, got, w)
+		}
+	}
+	close(release)
+	s.running.Wait()
+	if got, _ := s.Stats(hQueue); got.Runs != 2 || got.Running != 0 {
+		t.Errorf("queued run did not execute after the blocking run finished: %+v", got)
+	}
+	counts := map[Handle]int{}
+	for len(started) > 0 {
+		counts[<-started]++
+	}
+	if counts[hSkip] != 1 || counts[hQueue] != 2 || counts[hAllow] != 3 {
+		t.Errorf("unexpected callback counts %v", counts)
+	}
+}
+
+func TestSchedule_workers(t *testing.T) {
+	s := NewSchedule(WithWorkers(2))
+	var active, peak int32
+	for i := 0; i < 6; i++ {
+		s.AddJob(time.Hour, true, func(...interface{}) {
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 10)
+			atomic.AddInt32(&active, -1)
+		})
+	}
+	s.mutex.Lock()
+	for s.serviceJob(s.clock.Now()) {
+	}
+	s.mutex.Unlock()
+	s.running.Wait()
+	if peak != 2 {
+		t.Errorf("expected at most 2 concurrent callbacks, peak was %d", peak)
+	}
+}
+
+func TestSchedule_retry(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	failures := 2
+	h := s.AddFunc(time.Minute*5, true, func(ctx context.Context) error {
+		if failures > 0 {
+			failures--
+			return errors.New("unavailable")
+		}
+		return nil
+	}, WithRetry(Backoff{Initial: time.Second * 10, MaxRetries: 3}))
+	step := func(at time.Time) time.Time {
+		fc.Set(at)
+		s.ServiceNextJob()
+		s.running.Wait()
+		return s.jobs[0].nextRun
+	}
+	// initial run fails, retry after 10s
+	if next := step(t0); !next.Equal(t0.Add(time.Second * 10)) {
+		t.Fatalf("first retry at %v", next)
+	}
+	// second run fails, retry after a further 20s
+	if next := step(t0.Add(time.Second * 10)); !next.Equal(t0.Add(time.Second * 30)) {
+		t.Fatalf("second retry at %v", next)
+	}
+	// third run succeeds, back to the regular 5 minute cadence
+	if next := step(t0.Add(time.Second * 30)); !next.Equal(t0.Add(time.Minute * 5)) {
+		t.Fatalf("regular run at %v", next)
+	}
+	stats, _ := s.Stats(h)
+	if stats.Runs != 3 || stats.Failures != 2 || stats.Retries != 2 || stats.LastError != nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSchedule_badRetry(t *testing.T) {
+	var reported error
+	s := NewSchedule(WithErrorHandler(func(err error) { reported = err }))
+	noDelay := WithRetry(Backoff{MaxRetries: 0})
+	if h := s.AddFunc(time.Minute, false, nil, noDelay); h != 0 || reported == nil {
+		t.Errorf("retry without a delay gave handle %d, error %v", h, reported)
+	}
+	if _, err := s.AddCronFunc("0 * * * *", nil, noDelay); err == nil {
+		t.Errorf("cron job accepted a retry without a delay")
+	}
+	h := s.AddFunc(time.Minute, false, nil)
+	if err := s.SetOptions(h, WithName("market"), noDelay); err == nil {
+		t.Errorf("SetOptions accepted a retry without a delay")
+	}
+	if info, _ := s.Job(h); info.Name != "" {
+		t.Errorf("options applied although one was refused: %+v", info)
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 {
+		t.Errorf("%d jobs added", len(jobs))
+	}
+}
+
+func TestBackoff_delay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Second * 5, Multiplier: 3}
+	for attempt, want := range []time.Duration{1: time.Second, 2: time.Second * 3, 3: time.Second * 5, 4: time.Second * 5} {
+		if attempt > 0 && b.delay(attempt) != want {
+			t.Errorf("delay(%d) = %v, want %v", attempt, b.delay(attempt), want)
+		}
+	}
+}
+
+func TestSchedule_context(t *testing.T) {
+	s := NewSchedule()
+	ctx, cancel := context.WithCancel(context.Background())
+	deadline := make(chan bool, 1)
+	s.AddFunc(time.Hour, true, func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		deadline <- ok
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithTimeout(time.Minute))
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	select {
+	case ok := <-deadline:
+		if !ok {
+			t.Errorf("job context has no deadline")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job did not start")
+	}
+	// Run must cancel the in-flight job and wait for it
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if stats := s.jobs[0].stats; stats.LastError != context.Canceled {
+		t.Errorf("job finished with %v, want %v", stats.LastError, context.Canceled)
+	}
 }
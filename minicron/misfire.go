@@ -0,0 +1,97 @@
+package minicron
+
+import "time"
+
+// MisfirePolicy determines what a job does when the schedule finds that one or more of its scheduled runs have
+// already passed, e.g. after the host was suspended or Run was blocked.
+type MisfirePolicy int
+
+const (
+	// MisfireFireOnce runs the job once and continues from its next scheduled time after now (the default)
+	MisfireFireOnce MisfirePolicy = iota
+	// MisfireFireAll runs the job once now and then once for every missed run, one after another
+	MisfireFireAll
+	// MisfireSkip drops the overdue runs and waits for the job's next scheduled time after now
+	MisfireSkip
+)
+
+// Limit on missed cron runs counted individually, beyond this the count is approximate
+const maxMissedCount = 1000
+
+// MisfireEvent reports that a job was serviced after one or more further scheduled runs had passed
+type MisfireEvent struct {
+	Handle    Handle
+	Name      string
+	Policy    MisfirePolicy
+	Scheduled time.Time // the scheduled run which was due
+	At        time.Time // when the schedule got to it
+	Missed    int       // further scheduled runs which passed before At
+}
+
+// Return the job's first scheduled time after t
+func (j *Job) slotAfter(t time.Time) time.Time {
+	if j.once {
+		return time.Time{}
+	}
+	if j.cron != nil {
+		return j.cronNext(t)
+	}
+	return t.Add(j.Interval)
+}
+
+// Move the job's nextRun past the scheduled run being serviced at now, keeping it aligned to its schedule and
+// applying its misfire policy.  Returns false if the run should not happen.
+// mutex MUST be locked when running this
+func (sched *Schedule) advance(j *Job, now time.Time) bool {
+	scheduled := j.nextRun
+	j.nextRun = j.slotAfter(scheduled)
+	missed := 0
+	if j.cron == nil && j.Interval > 0 {
+		if !j.nextRun.After(now) {
+			missed = int(now.Sub(j.nextRun)/j.Interval) + 1
+			j.nextRun = j.nextRun.Add(time.Duration(missed) * j.Interval)
+		}
+	} else {
+		for !j.nextRun.IsZero() && !j.nextRun.After(now) {
+			missed++
+			if missed == maxMissedCount {
+				j.nextRun = j.cronNext(now)
+				break
+			}
+			j.nextRun = j.slotAfter(j.nextRun)
+		}
+	}
+	if missed == 0 {
+		return true
+	}
+	j.stats.Missed += uint64(missed)
+	sched.misfires = append(sched.misfires, MisfireEvent{
+		Handle:    j.handle,
+		Name:      j.name,
+		Policy:    j.misfire,
+		Scheduled: scheduled,
+		At:        now,
+		Missed:    missed,
+	})
+	switch j.misfire {
+	case MisfireFireAll:
+		j.pending += missed
+	case MisfireSkip:
+		j.stats.Missed++
+		return false
+	}
+	return true
+}
+
+// Pass misfire events collected while servicing jobs to the handler, must be called without mutex locked
+func (sched *Schedule) reportMisfires() {
+	sched.mutex.Lock()
+	events := sched.misfires
+	sched.misfires = nil
+	sched.mutex.Unlock()
+	if sched.onMisfire != nil {
+		for _, ev := range events {
+			sched.onMisfire(ev)
+		}
+	}
+}
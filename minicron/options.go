@@ -0,0 +1,144 @@
+package minicron
+
+import "time"
+
+// Option configures a Schedule when passed to NewSchedule
+type Option func(*Schedule)
+
+// JobOption configures a job, see Schedule.SetOptions
+type JobOption func(*Job)
+
+// WithWorkers sets the maximum number of job callbacks executing at the same time.
+func WithWorkers(n int) Option {
+	return func(sched *Schedule) {
+		if n < 1 {
+			n = 1
+		}
+		sched.workers = make(chan struct{}, n)
+	}
+}
+
+// WithClock sets the Clock used by the Schedule, e.g. a FakeClock for testing.
+func WithClock(clock Clock) Option {
+	return func(sched *Schedule) {
+		sched.clock = clock
+	}
+}
+
+// WithMisfireHandler sets a function called whenever a job is found to have missed scheduled runs.  It is called
+// from the goroutine servicing the schedule, so should not block.
+func WithMisfireHandler(f func(MisfireEvent)) Option {
+	return func(sched *Schedule) {
+		sched.onMisfire = f
+	}
+}
+
+// WithStateStore records the last run of every named job in store, and resumes jobs added with a name found in
+// store from their saved last run rather than their initialRun setting.  Names must be given when the job is
+// added (see WithName) for it to be restored.
+func WithStateStore(store StateStore) Option {
+	return func(sched *Schedule) {
+		sched.store = store
+	}
+}
+
+// WithErrorHandler sets a function called with errors which have no caller to return to, such as failures to
+// load or save job state.  It is called without the Schedule locked, so may use the Schedule.
+func WithErrorHandler(f func(error)) Option {
+	return func(sched *Schedule) {
+		sched.onError = f
+	}
+}
+
+// WithLocation sets the time zone cron jobs are evaluated in, unless the job has its own (see InLocation).
+// Without it the location of times from the Schedule's Clock is used, normally time.Local.  Interval jobs are
+// unaffected as they run at fixed elapsed times; use a cron job such as "0 7 * * *" to run at a wall clock time
+// which is kept across daylight saving changes.
+func WithLocation(loc *time.Location) Option {
+	return func(sched *Schedule) {
+		sched.loc = loc
+	}
+}
+
+// WithHooks sets functions called before and after every job run, and when a job panics.
+func WithHooks(hooks Hooks) Option {
+	return func(sched *Schedule) {
+		sched.hooks = hooks
+	}
+}
+
+// WithRateLimit limits how often jobs in the rate group named group may start, see WithRateGroup.
+func WithRateLimit(group string, limit RateLimit) Option {
+	return func(sched *Schedule) {
+		if sched.limits == nil {
+			sched.limits = make(map[string]*limiter)
+		}
+		sched.limits[group] = &limiter{RateLimit: limit}
+	}
+}
+
+// WithOverlap sets the job's policy for runs which become due while a previous run is still executing.
+func WithOverlap(policy OverlapPolicy) JobOption {
+	return func(j *Job) {
+		j.overlap = policy
+	}
+}
+
+// WithMisfire sets the job's policy for scheduled runs which have already passed when it is serviced.
+func WithMisfire(policy MisfirePolicy) JobOption {
+	return func(j *Job) {
+		j.misfire = policy
+	}
+}
+
+// WithTimeout cancels the context passed to the job's callback after d.
+func WithTimeout(d time.Duration) JobOption {
+	return func(j *Job) {
+		j.timeout = d
+	}
+}
+
+// WithRetry retries the job according to b when its callback returns an error.  Jobs given a Backoff whose
+// Initial delay is not positive are refused.
+func WithRetry(b Backoff) JobOption {
+	return func(j *Job) {
+		j.retry = &b
+	}
+}
+
+// WithName names the job so it can be found with Schedule.Lookup
+func WithName(name string) JobOption {
+	return func(j *Job) {
+		j.name = name
+	}
+}
+
+// WithTags attaches tags to the job for filtering Schedule.Jobs
+func WithTags(tags ...string) JobOption {
+	return func(j *Job) {
+		j.tags = append(j.tags, tags...)
+	}
+}
+
+// InLocation sets the time zone the job's cron spec is evaluated in.
+func InLocation(loc *time.Location) JobOption {
+	return func(j *Job) {
+		j.loc = loc
+	}
+}
+
+// WithJitter delays each run of the job by a random duration of up to d, e.g. so that several instances of a
+// program started together do not all call the same service at the same moment.  d should be well below the
+// job's interval.
+func WithJitter(d time.Duration) JobOption {
+	return func(j *Job) {
+		j.jitter = d
+	}
+}
+
+// WithRateGroup puts the job in a rate group whose runs are limited by the schedule, see WithRateLimit.
+func WithRateGroup(group string) JobOption {
+	return func(j *Job) {
+		j.group = group
+	}
+}
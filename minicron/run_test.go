@@ -0,0 +1,207 @@
+package minicron
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// Start Run on a schedule driven by a FakeClock, the returned function stops it
+func runFake(t *testing.T, opts ...Option) (*Schedule, *FakeClock, func()) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(append([]Option{WithClock(fc)}, opts...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	return s, fc, func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Run did not return after cancel")
+		}
+	}
+}
+
+// Wait until Run is asleep on its timer and no jobs are executing
+func settle(t *testing.T, s *Schedule, fc *FakeClock) {
+	deadline := time.Now().Add(time.Second)
+	for fc.Timers() != 1 || busy(s) {
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not go back to sleep")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func busy(s *Schedule) bool {
+	for _, info := range s.Jobs() {
+		if info.Stats.Running > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Expect exactly n values on c, returning them
+func expectRuns(t *testing.T, c chan time.Time, n int) []time.Time {
+	var got []time.Time
+	for i := 0; i < n; i++ {
+		select {
+		case at := <-c:
+			got = append(got, at)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d runs, got %d", n, i)
+		}
+	}
+	select {
+	case at := <-c:
+		t.Fatalf("unexpected extra run at %v", at)
+	case <-time.After(time.Millisecond * 20):
+	}
+	return got
+}
+
+// A JobFunc which reports the fake time it ran at
+func recorder(fc *FakeClock, c chan time.Time) JobFunc {
+	return func(context.Context) error {
+		c <- fc.Now()
+		return nil
+	}
+}
+
+func TestRun_initialRun(t *testing.T) {
+	s, fc, stop := runFake(t)
+	defer stop()
+	initial, delayed := make(chan time.Time, 10), make(chan time.Time, 10)
+	s.AddFunc(time.Minute, true, recorder(fc, initial))
+	s.AddFunc(time.Minute, false, recorder(fc, delayed))
+	if got := expectRuns(t, initial, 1); !got[0].Equal(t0) {
+		t.Errorf("initial run at %v, want %v", got[0], t0)
+	}
+	expectRuns(t, delayed, 0)
+	settle(t, s, fc)
+	fc.Advance(time.Minute)
+	expectRuns(t, initial, 1)
+	if got := expectRuns(t, delayed, 1); !got[0].Equal(t0.Add(time.Minute)) {
+		t.Errorf("first run at %v, want %v", got[0], t0.Add(time.Minute))
+	}
+}
+
+func TestRun_noDrift(t *testing.T) {
+	s, fc, stop := runFake(t)
+	defer stop()
+	c := make(chan time.Time, 10)
+	h := s.AddFunc(time.Second*5, false, recorder(fc, c))
+	for i := 1; i <= 100; i++ {
+		settle(t, s, fc)
+		fc.Advance(time.Second * 5)
+		if at := <-c; !at.Equal(t0.Add(time.Duration(i) * time.Second * 5)) {
+			t.Fatalf("run %d at %v", i, at)
+		}
+	}
+	if stats, _ := s.Stats(h); stats.Runs != 100 {
+		t.Errorf("expected 100 runs, got %d", stats.Runs)
+	}
+}
+
+func TestRun_removeDuringExecution(t *testing.T) {
+	s, fc, stop := runFake(t)
+	defer stop()
+	c := make(chan time.Time, 10)
+	release := make(chan struct{})
+	var h Handle
+	h = s.AddFunc(time.Second, false, func(context.Context) error {
+		c <- fc.Now()
+		s.RemoveJob(h)
+		<-release
+		return nil
+	}, WithOverlap(OverlapQueue))
+	other := make(chan time.Time, 10)
+	s.AddFunc(time.Second, false, recorder(fc, other))
+	settle(t, s, fc)
+	fc.Advance(time.Second)
+	expectRuns(t, c, 1)
+	for i := 0; i < 3; i++ {
+		settle(t, s, fc)
+		fc.Advance(time.Second)
+	}
+	expectRuns(t, other, 4)
+	close(release)
+	expectRuns(t, c, 0)
+	if _, ok := s.Stats(h); ok {
+		t.Errorf("removed job still in schedule")
+	}
+}
+
+func TestRun_catchUp(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		misfires []MisfireEvent
+	)
+	s, fc, stop := runFake(t, WithMisfireHandler(func(ev MisfireEvent) {
+		mutex.Lock()
+		misfires = append(misfires, ev)
+		mutex.Unlock()
+	}))
+	defer stop()
+	once, all, skip := make(chan time.Time, 20), make(chan time.Time, 20), make(chan time.Time, 20)
+	hOnce := s.AddFunc(time.Minute*5, false, recorder(fc, once))
+	hAll := s.AddFunc(time.Minute*5, false, recorder(fc, all), WithMisfire(MisfireFireAll))
+	hSkip := s.AddFunc(time.Minute*5, false, recorder(fc, skip), WithMisfire(MisfireSkip))
+	settle(t, s, fc)
+	// e.g. the host was suspended for an hour, the runs due at 10:10 through 11:00 were missed
+	fc.Advance(time.Hour + time.Second)
+	expectRuns(t, once, 1)
+	expectRuns(t, all, 12)
+	expectRuns(t, skip, 0)
+	settle(t, s, fc)
+	mutex.Lock()
+	if len(misfires) != 3 {
+		t.Fatalf("expected 3 misfire events, got %+v", misfires)
+	}
+	for _, ev := range misfires {
+		if ev.Missed != 11 || !ev.Scheduled.Equal(t0.Add(time.Minute*5)) || !ev.At.Equal(t0.Add(time.Hour+time.Second)) {
+			t.Errorf("unexpected misfire event %+v", ev)
+		}
+	}
+	mutex.Unlock()
+	for h, missed := range map[Handle]uint64{hOnce: 11, hAll: 11, hSkip: 12} {
+		if info, _ := s.Job(h); info.Stats.Missed != missed || !info.NextRun.Equal(t0.Add(time.Minute*65)) {
+			t.Errorf("job %d missed %d, next run %v", h, info.Stats.Missed, info.NextRun)
+		}
+	}
+	// every policy is back on the schedule's original alignment
+	fc.Advance(time.Minute*5 - time.Second)
+	for _, c := range []chan time.Time{once, all, skip} {
+		if got := expectRuns(t, c, 1); !got[0].Equal(t0.Add(time.Minute * 65)) {
+			t.Errorf("run after catch up at %v", got[0])
+		}
+	}
+}
+
+func TestRun_alignment(t *testing.T) {
+	s, fc, stop := runFake(t)
+	defer stop()
+	c := make(chan time.Time, 10)
+	fc.Advance(time.Second * 7)
+	// a job added later is aligned to the schedule's basetime, not the time it was added
+	s.AddFunc(time.Second*5, false, recorder(fc, c))
+	settle(t, s, fc)
+	fc.Advance(time.Second * 4)
+	// serviced late, but the following run is still on the 5 second grid
+	if got := expectRuns(t, c, 1); !got[0].Equal(t0.Add(time.Second * 11)) {
+		t.Errorf("late run at %v", got[0])
+	}
+	settle(t, s, fc)
+	fc.Advance(time.Second * 4)
+	if got := expectRuns(t, c, 1); !got[0].Equal(t0.Add(time.Second * 15)) {
+		t.Errorf("aligned run at %v", got[0])
+	}
+}
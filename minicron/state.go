@@ -0,0 +1,125 @@
+package minicron
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JobState is what a StateStore records about a named job after each run
+type JobState struct {
+	LastRun   time.Time `json:"lastRun"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// StateStore persists the state of named jobs so a restarted Schedule can resume their cadence.  See
+// WithStateStore.
+type StateStore interface {
+	Load(name string) (JobState, bool, error)
+	Save(name string, state JobState) error
+}
+
+// FileStore is a StateStore which keeps the state of all jobs in one JSON file.  The file is rewritten
+// atomically on every Save.
+type FileStore struct {
+	mutex  sync.Mutex
+	path   string
+	states map[string]JobState
+}
+
+// NewFileStore opens a FileStore, loading path if it exists.
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{
+		path:   path,
+		states: make(map[string]JobState),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &fs.states); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) Load(name string) (JobState, bool, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	state, ok := fs.states[name]
+	return state, ok, nil
+}
+
+func (fs *FileStore) Save(name string, state JobState) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.states[name] = state
+	data, err := json.MarshalIndent(fs.states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
+
+// Resume a newly added named job from its saved state, so it next runs when it would have had the schedule not
+// been restarted.  A job which became due while the schedule was stopped runs immediately, subject to its
+// MisfirePolicy.  An error loading the state is returned for the caller to report once the mutex is unlocked, so
+// the error handler can use the Schedule.
+// mutex MUST be locked when running this
+func (sched *Schedule) restore(j *Job) error {
+	if sched.store == nil || len(j.name) == 0 {
+		return nil
+	}
+	state, ok, err := sched.store.Load(j.name)
+	if err != nil {
+		return err
+	}
+	if !ok || state.LastRun.IsZero() {
+		return nil
+	}
+	j.lastRun = state.LastRun
+	if len(state.LastError) > 0 {
+		j.stats.LastError = savedError(state.LastError)
+	}
+	j.nextRun = j.slotAfter(state.LastRun)
+	if j.nextRun.IsZero() {
+		// the cron spec has no more matches after the last run, let it run from now
+		j.calcNextRun()
+	}
+	return nil
+}
+
+// Record a finished run of a named job, must be called without mutex locked
+func (sched *Schedule) saveState(name string, started time.Time, err error) {
+	state := JobState{LastRun: started}
+	if err != nil {
+		state.LastError = err.Error()
+	}
+	if err = sched.store.Save(name, state); err != nil {
+		sched.reportError(err)
+	}
+}
+
+// savedError is the error of a job's last run before the schedule was restarted
+type savedError string
+
+func (e savedError) Error() string {
+	return string(e)
+}
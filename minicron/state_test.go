@@ -0,0 +1,99 @@
+package minicron
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := fs.Load("calendar"); ok {
+		t.Errorf("empty store returned state")
+	}
+	want := JobState{LastRun: t0, LastError: "timeout"}
+	if err = fs.Save("calendar", want); err != nil {
+		t.Fatal(err)
+	}
+	if fs, err = NewFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := fs.Load("calendar"); !ok || !got.LastRun.Equal(want.LastRun) || got.LastError != want.LastError {
+		t.Errorf("reloaded state %+v, want %+v", got, want)
+	}
+}
+
+func TestSchedule_restoreState(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := errors.New("quota exceeded")
+	fn := func(context.Context) error { return fail }
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc), WithStateStore(fs))
+	s.AddFunc(time.Minute*5, true, fn, WithName("market"))
+	serviceDue(s)
+	if state, ok, _ := fs.Load("market"); !ok || !state.LastRun.Equal(t0) || state.LastError != fail.Error() {
+		t.Fatalf("saved state %+v", state)
+	}
+
+	// Restarting within the interval keeps the original cadence instead of running immediately
+	fc.Set(t0.Add(time.Minute * 2))
+	s = NewSchedule(WithClock(fc), WithStateStore(fs))
+	h := s.AddFunc(time.Minute*5, true, fn, WithName("market"))
+	hNew := s.AddFunc(time.Minute*5, true, fn, WithName("calendar"))
+	info, _ := s.Job(h)
+	if !info.NextRun.Equal(t0.Add(time.Minute*5)) || info.Stats.LastError == nil {
+		t.Errorf("restored job %+v", info)
+	}
+	if info, _ = s.Job(hNew); !info.NextRun.Equal(fc.Now()) {
+		t.Errorf("job without saved state did not get its initial run %+v", info)
+	}
+
+	// Restarting after the job was due runs it straight away
+	fc.Set(t0.Add(time.Minute * 7))
+	s = NewSchedule(WithClock(fc), WithStateStore(fs))
+	h = s.AddFunc(time.Minute*5, false, fn, WithName("market"))
+	serviceDue(s)
+	if info, _ = s.Job(h); info.Stats.Runs != 1 || !info.NextRun.Equal(t0.Add(time.Minute*10)) {
+		t.Errorf("overdue restored job %+v", info)
+	}
+}
+
+// A StateStore which cannot be read
+type brokenStore struct{}
+
+func (brokenStore) Load(name string) (JobState, bool, error) {
+	return JobState{}, false, errors.New("store unreadable")
+}
+
+func (brokenStore) Save(name string, state JobState) error {
+	return nil
+}
+
+func TestSchedule_restoreError(t *testing.T) {
+	var s *Schedule
+	var seen []int
+	// the handler uses the Schedule, which must not be locked when it is called
+	s = NewSchedule(WithStateStore(brokenStore{}), WithErrorHandler(func(err error) {
+		seen = append(seen, len(s.Jobs()))
+	}))
+	h := s.AddFunc(time.Minute, false, nil, WithName("market"))
+	if _, err := s.AddCronFunc("0 * * * *", nil, WithName("render")); err != nil {
+		t.Fatal(err)
+	}
+	// each error is reported once its job has been added
+	if len(seen) != 2 || seen[0] != 1 || seen[1] != 2 {
+		t.Errorf("handler saw jobs %v", seen)
+	}
+	if _, ok := s.Job(h); !ok {
+		t.Errorf("job not added after its state failed to load")
+	}
+}
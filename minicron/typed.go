@@ -0,0 +1,27 @@
+package minicron
+
+import (
+	"context"
+	"time"
+)
+
+// Bind returns a JobFunc which calls fn with v.  Unlike a CallBack with ...interface{} parameters, passing a
+// value of the wrong type is a compile time error.
+func Bind[T any](fn func(context.Context, T) error, v T) JobFunc {
+	if fn == nil {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		return fn(ctx, v)
+	}
+}
+
+// AddTyped adds a job which calls fn with v every duration, see Schedule.AddFunc.
+func AddTyped[T any](sched *Schedule, duration time.Duration, initialRun bool, fn func(context.Context, T) error, v T, opts ...JobOption) Handle {
+	return sched.AddFunc(duration, initialRun, Bind(fn, v), opts...)
+}
+
+// AddTypedCron adds a job which calls fn with v at the times matched by spec, see Schedule.AddCronFunc.
+func AddTypedCron[T any](sched *Schedule, spec string, fn func(context.Context, T) error, v T, opts ...JobOption) (Handle, error) {
+	return sched.AddCronFunc(spec, Bind(fn, v), opts...)
+}
@@ -0,0 +1,38 @@
+package minicron
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type counter struct {
+	n int
+}
+
+func increment(ctx context.Context, c *counter) error {
+	c.n++
+	return nil
+}
+
+func TestAddTyped(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	var c counter
+	AddTyped(s, time.Minute, true, increment, &c, WithName("typed"))
+	if _, err := AddTypedCron(s, "*/2 * * * *", increment, &c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddTypedCron(s, "bad", increment, &c); err == nil {
+		t.Errorf("expected error for invalid cron spec")
+	}
+	serviceDue(s)
+	fc.Advance(time.Minute * 2)
+	serviceDue(s)
+	if c.n != 3 {
+		t.Errorf("typed jobs ran %d times, want 3", c.n)
+	}
+	if Bind[*counter](nil, &c) != nil {
+		t.Errorf("Bind of nil func should be nil")
+	}
+}
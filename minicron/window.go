@@ -0,0 +1,45 @@
+package minicron
+
+import "time"
+
+// A daily period in which a job is active, as offsets from midnight
+type window struct {
+	start, end time.Duration
+}
+
+// Report whether the wall clock time of t falls within the window.  A window whose end is before its start spans
+// midnight.
+func (w *window) contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second +
+		time.Duration(t.Nanosecond())
+	if w.start <= w.end {
+		return d >= w.start && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// Report whether the job may run at t, counting the run as skipped if not
+// mutex MUST be locked when running this
+func (j *Job) active(t time.Time) bool {
+	if j.window == nil {
+		return true
+	}
+	if j.loc != nil {
+		t = t.In(j.loc)
+	}
+	if j.window.contains(t) {
+		return true
+	}
+	j.stats.Skipped++
+	return false
+}
+
+// WithWindow restricts the job to running between start and end each day, given as offsets from midnight in the
+// job's location (see InLocation).  Scheduled or triggered runs falling outside the window are skipped; RunNow
+// still runs the job.  If end is before start the window spans midnight.
+func WithWindow(start, end time.Duration) JobOption {
+	return func(j *Job) {
+		j.window = &window{start: start, end: end}
+	}
+}
@@ -0,0 +1,82 @@
+package minicron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_AddOnce(t *testing.T) {
+	fc := NewFakeClock(t0)
+	s := NewSchedule(WithClock(fc))
+	runs := 0
+	late := s.AddOnce(t0.Add(-time.Minute*5), countRuns(&runs, new(error)))
+	h := s.AddOnce(t0.Add(time.Minute*55), countRuns(&runs, new(error)), WithName("reminder"))
+	if info, _ := s.Job(h); !info.Once || !info.NextRun.Equal(t0.Add(time.Minute*55)) {
+		t.Errorf("one-shot job %+v", info)
+	}
+	serviceDue(s)
+	if _, ok := s.Job(late); ok || runs != 1 {
+		t.Errorf("one-shot job in the past did not run immediately, runs=%d", runs)
+	}
+	fc.Advance(time.Minute * 55)
+	serviceDue(s)
+	if _, ok := s.Job(h); ok || runs != 2 {
+		t.Errorf("one-shot job not run and removed, runs=%d", runs)
+	}
+	fc.Advance(time.Hour)
+	serviceDue(s)
+	if runs != 2 {
+		t.Errorf("one-shot job ran again")
+	}
+}
+
+func TestWindow_contains(t *testing.T) {
+	day := &window{start: time.Hour*9 + time.Minute*30, end: time.Hour * 16}
+	night := &window{start: time.Hour * 22, end: time.Hour * 6}
+	for _, tc := range []struct {
+		w    *window
+		at   string
+		want bool
+	}{
+		{day, "09:29", false},
+		{day, "09:30", true},
+		{day, "15:59", true},
+		{day, "16:00", false},
+		{night, "21:59", false},
+		{night, "23:00", true},
+		{night, "03:00", true},
+		{night, "06:00", false},
+	} {
+		at, _ := time.Parse("15:04", tc.at)
+		if got := tc.w.contains(at); got != tc.want {
+			t.Errorf("window %v-%v contains %s = %v", tc.w.start, tc.w.end, tc.at, got)
+		}
+	}
+}
+
+func TestSchedule_window(t *testing.T) {
+	ny := newYork(t)
+	// 09:00 EST
+	fc := NewFakeClock(time.Date(2021, 3, 1, 14, 0, 0, 0, time.UTC))
+	s := NewSchedule(WithClock(fc))
+	h := s.AddFunc(time.Minute*15, false, nil, WithWindow(time.Hour*9+time.Minute*30, time.Hour*16), InLocation(ny))
+	for i := 0; i < 4; i++ {
+		fc.Advance(time.Minute * 15)
+		serviceDue(s)
+	}
+	// 09:15 skipped, 09:30, 09:45 and 10:00 run
+	if info, _ := s.Job(h); info.Stats.Runs != 3 || info.Stats.Skipped != 1 {
+		t.Errorf("runs %d skipped %d", info.Stats.Runs, info.Stats.Skipped)
+	}
+	fc.Set(time.Date(2021, 3, 1, 21, 0, 0, 0, time.UTC))
+	serviceDue(s)
+	if info, _ := s.Job(h); info.Stats.Runs != 3 || info.Stats.Skipped != 2 {
+		t.Errorf("run at 16:00 not skipped, runs %d skipped %d", info.Stats.Runs, info.Stats.Skipped)
+	}
+	// RunNow overrides the window
+	s.RunNow(h)
+	s.running.Wait()
+	if info, _ := s.Job(h); info.Stats.Runs != 4 {
+		t.Errorf("RunNow outside window did not run")
+	}
+}
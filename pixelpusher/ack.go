@@ -0,0 +1,132 @@
+package pixelpusher
+
+import (
+	"errors"
+	"github.com/jjcinaz/panelserver/bytesbuffer"
+	"net"
+	"time"
+)
+
+// Health is what a Client knows of its panel when frames are acknowledged, see WithAck
+type Health struct {
+	Online   bool      // the panel has acknowledged a frame and not since let one go unacknowledged
+	LastAck  time.Time // when the panel last acknowledged a frame, zero if it never has
+	Sequence uint32    // sequence of the last frame acknowledged
+	Pending  bool      // a frame is waiting to be acknowledged
+}
+
+// WithAck asks the panel to acknowledge every frame, which needs ProtocolV1 and a panel which supports it.  A frame
+// not acknowledged within timeout is sent again, up to retries times, after which the panel is reported offline.
+// Only the latest frame is resent: a new frame replaces one still waiting and, as the panel may have missed rows
+// of either, is sent as a keyframe.
+func WithAck(timeout time.Duration, retries int) Option {
+	return func(c *Client) {
+		c.ack.timeout = timeout
+		c.ack.retries = retries
+	}
+}
+
+// Acknowledgement state of a Client
+type ackState struct {
+	timeout  time.Duration
+	retries  int
+	pending  []bytesbuffer.Buffer // packets of the latest frame until it is acknowledged
+	sequence uint32               // of the pending frame
+	attempts int
+	timer    *time.Timer
+	health   Health
+}
+
+func (a *ackState) enabled() bool {
+	return a.timeout > 0
+}
+
+// Health returns the state of the panel as seen from its acknowledgements.  It is always offline unless WithAck
+// is used.
+func (c *Client) Health() Health {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	h := c.ack.health
+	h.Pending = c.ack.pending != nil
+	return h
+}
+
+// Wait for the panel to acknowledge the frame just built, replacing any frame still waiting
+// mutex MUST be locked when running this
+func (c *Client) expectAck(packets []bytesbuffer.Buffer) {
+	c.stopAck()
+	c.ack.pending = packets
+	c.ack.sequence = c.sequence - 1
+	c.ack.attempts = 0
+	sequence := c.ack.sequence
+	c.ack.timer = time.AfterFunc(c.ack.timeout, func() { c.ackTimeout(sequence) })
+}
+
+// Stop waiting for an acknowledgement
+// mutex MUST be locked when running this
+func (c *Client) stopAck() {
+	if c.ack.timer != nil {
+		c.ack.timer.Stop()
+		c.ack.timer = nil
+	}
+	c.ack.pending = nil
+}
+
+// Resend the frame waiting for an acknowledgement, or give up on it once out of retries
+func (c *Client) ackTimeout(sequence uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ack.pending == nil || c.ack.sequence != sequence {
+		// acknowledged or replaced by a newer frame since the timer fired
+		return
+	}
+	if c.ack.attempts >= c.ack.retries {
+		c.stopAck()
+		c.ack.health.Online = false
+		c.prev = nil
+		return
+	}
+	c.ack.attempts++
+	for _, buf := range c.ack.pending {
+		if c.send(buf) != nil {
+			break
+		}
+	}
+	c.ack.timer = time.AfterFunc(c.ack.timeout, func() { c.ackTimeout(sequence) })
+}
+
+// Read acknowledgements from the panel until conn is closed
+func (c *Client) readAcks(conn *net.UDPConn) {
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// nothing listening at the panel's address is reported as a read error, keep waiting for it
+			continue
+		}
+		sequence, err := ParseAck(buf[:n])
+		if err != nil {
+			continue
+		}
+		c.mutex.Lock()
+		c.acked(sequence)
+		c.mutex.Unlock()
+	}
+}
+
+// Record an acknowledgement of frame sequence, which also covers any earlier frame
+// mutex MUST be locked when running this
+func (c *Client) acked(sequence uint32) {
+	h := &c.ack.health
+	if h.LastAck.IsZero() || int32(sequence-h.Sequence) > 0 {
+		h.Sequence = sequence
+	}
+	h.Online = true
+	h.LastAck = time.Now()
+	if c.ack.pending != nil && int32(sequence-c.ack.sequence) >= 0 {
+		c.stopAck()
+	}
+}
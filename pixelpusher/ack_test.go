@@ -0,0 +1,136 @@
+package pixelpusher
+
+import (
+	"bytes"
+	"image"
+	"net"
+	"testing"
+	"time"
+)
+
+// Receive a packet and the address it came from
+func receiveFrom(t *testing.T, conn *net.UDPConn) ([]byte, *net.UDPAddr) {
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, addr, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n], addr
+}
+
+// Wait for the Client's health to satisfy ok
+func waitHealth(t *testing.T, c *Client, ok func(Health) bool) Health {
+	deadline := time.Now().Add(time.Second)
+	for {
+		h := c.Health()
+		if ok(h) {
+			return h
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health %+v", h)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAck_parse(t *testing.T) {
+	ack := AppendAck(nil, 1234)
+	if seq, err := ParseAck(ack); err != nil || seq != 1234 {
+		t.Errorf("ParseAck = %d, %v", seq, err)
+	}
+	c, _ := NewClient("udp", "localhost", 2, 2, WithProtocol(ProtocolV1), WithAck(time.Second, 1))
+	pkt := c.createPackets(image.NewRGBA(image.Rect(0, 0, 2, 2)))[0].Bytes()
+	if pkt[3]&FlagAck == 0 {
+		t.Errorf("frame does not ask for an acknowledgement")
+	}
+	if _, err := ParseAck(pkt); err == nil {
+		t.Errorf("frame parsed as an acknowledgement")
+	}
+	if _, err := NewClient("udp", "localhost", 2, 2, WithAck(time.Second, 1)); err == nil {
+		t.Errorf("acknowledgements accepted with ProtocolLegacy")
+	}
+}
+
+func TestClient_ack(t *testing.T) {
+	conn, addr := listen(t)
+	c, err := NewClient("udp", addr, 2, 2, WithProtocol(ProtocolV1), WithAck(time.Second, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if h := c.Health(); h.Online || !h.LastAck.IsZero() {
+		t.Errorf("online before any acknowledgement: %+v", h)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	if err = c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	if h := c.Health(); !h.Pending {
+		t.Errorf("frame not waiting for its acknowledgement: %+v", h)
+	}
+	_, from := receiveFrom(t, conn)
+	conn.WriteToUDP(AppendAck(nil, 1), from)
+	h := waitHealth(t, c, func(h Health) bool { return h.Online })
+	if h.Pending || h.Sequence != 1 || h.LastAck.IsZero() {
+		t.Errorf("health after acknowledgement %+v", h)
+	}
+}
+
+func TestClient_ackRetransmit(t *testing.T) {
+	conn, addr := listen(t)
+	c, _ := NewClient("udp", addr, 2, 2, WithProtocol(ProtocolV1), WithDelta(0), WithAck(time.Millisecond*20, 2))
+	defer c.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	c.SendImage(img)
+	first, from := receiveFrom(t, conn)
+	conn.WriteToUDP(AppendAck(nil, 1), from)
+	waitHealth(t, c, func(h Health) bool { return h.Online && !h.Pending })
+
+	// frame 2 is lost, a delta frame without rows, and is resent until it is given up on
+	c.SendImage(img)
+	second, _ := receiveFrom(t, conn)
+	for i := 0; i < 2; i++ {
+		if again, _ := receiveFrom(t, conn); !bytes.Equal(again, second) {
+			t.Fatalf("resend %d differs from the frame", i+1)
+		}
+	}
+	h := waitHealth(t, c, func(h Health) bool { return !h.Pending })
+	if h.Online || h.Sequence != 1 {
+		t.Errorf("health after retries ran out %+v", h)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 60))
+	if _, err := conn.Read(make([]byte, 100)); err == nil {
+		t.Errorf("frame resent after retries ran out")
+	}
+	// the panel may have missed rows so the next frame is whole
+	c.SendImage(img)
+	third, _ := receiveFrom(t, conn)
+	if third[3]&FlagKeyframe == 0 || len(third) != len(first) {
+		t.Errorf("frame after a lost frame is not a keyframe")
+	}
+}
+
+func TestClient_ackLatestOnly(t *testing.T) {
+	conn, addr := listen(t)
+	c, _ := NewClient("udp", addr, 2, 2, WithProtocol(ProtocolV1), WithDelta(0), WithAck(time.Millisecond*50, 1))
+	defer c.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	c.SendImage(img)
+	receiveFrom(t, conn)
+	// frame 2 replaces frame 1 before it is acknowledged, so is a keyframe and the only one resent
+	c.SendImage(img)
+	second, from := receiveFrom(t, conn)
+	if h, _ := parseHeader(second); h.sequence != 2 || h.flags&FlagKeyframe == 0 {
+		t.Errorf("second frame %+v, want a keyframe", h)
+	}
+	again, _ := receiveFrom(t, conn)
+	if !bytes.Equal(again, second) {
+		t.Errorf("resent packet is not the latest frame")
+	}
+	// acknowledging the latest frame covers the earlier one
+	conn.WriteToUDP(AppendAck(nil, 2), from)
+	if h := waitHealth(t, c, func(h Health) bool { return h.Online }); h.Pending || h.Sequence != 2 {
+		t.Errorf("health %+v", h)
+	}
+}
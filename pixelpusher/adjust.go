@@ -0,0 +1,115 @@
+package pixelpusher
+
+import (
+	"fmt"
+	"math"
+)
+
+// ColorOrder is the order a panel expects the red, green and blue bytes of each pixel in
+type ColorOrder int
+
+const (
+	OrderRGB ColorOrder = iota
+	OrderRBG
+	OrderGRB
+	OrderGBR
+	OrderBRG
+	OrderBGR
+)
+
+// Source channel sent in each position for each ColorOrder
+var colorOrders = [...][3]int{
+	OrderRGB: {0, 1, 2},
+	OrderRBG: {0, 2, 1},
+	OrderGRB: {1, 0, 2},
+	OrderGBR: {1, 2, 0},
+	OrderBRG: {2, 0, 1},
+	OrderBGR: {2, 1, 0},
+}
+
+// Color adjustments applied to every pixel sent
+type adjustment struct {
+	gamma        float64
+	brightness   float64
+	whiteBalance [3]float64
+	order        ColorOrder
+	lut          *[3][256]byte // nil when no channel values are changed
+}
+
+// WithGamma corrects pixel values for the panel's response, sending 255*(v/255)^gamma for each channel value v.
+// LED panels typically need a gamma of around 2.2 for colors to look right.
+func WithGamma(gamma float64) Option {
+	return func(c *Client) {
+		c.adjust.gamma = gamma
+	}
+}
+
+// WithBrightness scales every channel by brightness, 0 to 1, e.g. to limit a panel's power draw.
+func WithBrightness(brightness float64) Option {
+	return func(c *Client) {
+		c.adjust.brightness = brightness
+	}
+}
+
+// WithWhiteBalance scales the red, green and blue channels separately, each 0 to 1, to correct a panel's tint.
+func WithWhiteBalance(r, g, b float64) Option {
+	return func(c *Client) {
+		c.adjust.whiteBalance = [3]float64{r, g, b}
+	}
+}
+
+// WithColorOrder sends each pixel's channels in the order a panel is wired for.
+func WithColorOrder(order ColorOrder) Option {
+	return func(c *Client) {
+		c.adjust.order = order
+	}
+}
+
+func newAdjustment() adjustment {
+	return adjustment{gamma: 1, brightness: 1, whiteBalance: [3]float64{1, 1, 1}}
+}
+
+// Check the adjustment's settings and build its lookup table
+func (a *adjustment) prepare() error {
+	if a.gamma <= 0 {
+		return fmt.Errorf("gamma must be positive")
+	}
+	if a.brightness < 0 || a.brightness > 1 {
+		return fmt.Errorf("brightness out of range, must be 0...1")
+	}
+	for _, wb := range a.whiteBalance {
+		if wb < 0 || wb > 1 {
+			return fmt.Errorf("white balance out of range, must be 0...1")
+		}
+	}
+	if a.order < OrderRGB || a.order > OrderBGR {
+		return fmt.Errorf("invalid color order %d", a.order)
+	}
+	a.lut = nil
+	if a.gamma == 1 && a.brightness == 1 && a.whiteBalance == [3]float64{1, 1, 1} {
+		return nil
+	}
+	a.lut = new([3][256]byte)
+	for ch := range a.lut {
+		for v := range a.lut[ch] {
+			out := 255 * math.Pow(float64(v)/255, a.gamma) * a.brightness * a.whiteBalance[ch]
+			a.lut[ch][v] = byte(math.Round(out))
+		}
+	}
+	return nil
+}
+
+// Apply the adjustment in place to a row of RGB pixels
+func (a *adjustment) apply(row []byte) {
+	if a.lut == nil && a.order == OrderRGB {
+		return
+	}
+	order := colorOrders[a.order]
+	for i := 0; i+2 < len(row); i += 3 {
+		px := [3]byte{row[i], row[i+1], row[i+2]}
+		if a.lut != nil {
+			px = [3]byte{a.lut[0][px[0]], a.lut[1][px[1]], a.lut[2][px[2]]}
+		}
+		row[i], row[i+1], row[i+2] = px[order[0]], px[order[1]], px[order[2]]
+	}
+}
@@ -0,0 +1,47 @@
+package pixelpusher
+
+import (
+	"testing"
+)
+
+func TestAdjustment(t *testing.T) {
+	a := newAdjustment()
+	a.gamma = 2.2
+	a.brightness = 0.5
+	a.whiteBalance = [3]float64{1, 0.8, 1}
+	a.order = OrderGRB
+	if err := a.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	row := []byte{255, 255, 0, 128, 0, 64}
+	a.apply(row)
+	// 255*(128/255)^2.2*0.5 = 27.5, 255*(64/255)^2.2*0.5 = 6.0
+	want := []byte{102, 128, 0, 0, 28, 6}
+	for i := range want {
+		if row[i] != want[i] {
+			t.Fatalf("adjusted row %v, want %v", row, want)
+		}
+	}
+
+	a = newAdjustment()
+	a.order = OrderBGR
+	if err := a.prepare(); err != nil || a.lut != nil {
+		t.Fatalf("color order alone built a lookup table: %v", err)
+	}
+	row = []byte{1, 2, 3}
+	if a.apply(row); row[0] != 3 || row[1] != 2 || row[2] != 1 {
+		t.Errorf("BGR row %v", row)
+	}
+
+	for _, bad := range []func(*adjustment){
+		func(a *adjustment) { a.gamma = 0 },
+		func(a *adjustment) { a.brightness = 1.5 },
+		func(a *adjustment) { a.whiteBalance[1] = -1 },
+		func(a *adjustment) { a.order = OrderBGR + 1 },
+	} {
+		a = newAdjustment()
+		if bad(&a); a.prepare() == nil {
+			t.Errorf("invalid adjustment %+v accepted", a)
+		}
+	}
+}
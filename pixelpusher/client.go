@@ -1,88 +1,340 @@
 package pixelpusher
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/jjcinaz/panelserver/bytesbuffer"
 	"image"
-	"image/color"
 	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
+// DefaultResolveInterval is how often a Client looks up the panel's address again unless WithResolveInterval is used.
+const DefaultResolveInterval = time.Minute * 5
+
+// Largest number of rows or columns in a frame
+const maxDimension = 1024
+
+// Client sends frames to a panel over UDP.  It keeps its socket between frames and is safe for concurrent use.
 type Client struct {
-	sequence   uint32
-	network    string
-	address    string
-	rows, cols int
-	conn       *net.UDPConn
+	mutex           sync.Mutex
+	sequence        uint32
+	network         string
+	address         string
+	rows, cols      int
+	conn            *net.UDPConn
+	resolved        time.Time // when the address conn was dialled to was looked up
+	resolveInterval time.Duration
+	mtu             int
+	protocol        Protocol
+	adjust          adjustment
+	compression     Compression
+	depth           depth
+	// delta frames, the rows of the last frame sent and how many frames have been sent since the last keyframe
+	delta            bool
+	keyframeInterval int
+	prev             [][]byte
+	sinceKeyframe    int
+	ack              ackState
+}
+
+// Option configures a Client when passed to NewClient
+type Option func(*Client)
+
+// WithResolveInterval sets how often the panel's address is looked up again, so a panel whose DNS name moves to a
+// new IP address is followed.  Zero only looks it up again after a send fails.
+func WithResolveInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.resolveInterval = d
+	}
+}
+
+// WithMTU splits frames into packets of at most mtu bytes, each holding whole rows.  Without it a frame is sent as
+// a single datagram, which is fine for small panels but limits a frame to under 64KB and relies on IP
+// fragmentation above the network's MTU.
+func WithMTU(mtu int) Option {
+	return func(c *Client) {
+		c.mtu = mtu
+	}
 }
 
-func NewClient(network, address string, rows, cols int) (*Client, error) {
-	if rows < 1 || rows > 1024 {
-		return nil, fmt.Errorf("rows out of range, must be 1...1024")
+// WithProtocol sets the packet format, ProtocolLegacy unless given.
+func WithProtocol(p Protocol) Option {
+	return func(c *Client) {
+		c.protocol = p
 	}
-	if cols < 1 || cols > 1024 {
-		return nil, fmt.Errorf("cols out of range, must be 1...1024")
+}
+
+// DefaultKeyframeInterval is how often a full frame is sent when WithDelta is given an interval of zero.
+const DefaultKeyframeInterval = 60
+
+// WithDelta only sends the rows of each frame which differ from the previous frame, with a full keyframe every
+// keyframeInterval frames, or DefaultKeyframeInterval if zero.  It needs ProtocolV1.
+func WithDelta(keyframeInterval int) Option {
+	return func(c *Client) {
+		c.delta = true
+		c.keyframeInterval = keyframeInterval
+		if c.keyframeInterval <= 0 {
+			c.keyframeInterval = DefaultKeyframeInterval
+		}
+	}
+}
+
+func NewClient(network, address string, rows, cols int, opts ...Option) (*Client, error) {
+	if rows < 1 || rows > maxDimension {
+		return nil, fmt.Errorf("rows out of range, must be 1...%d", maxDimension)
+	}
+	if cols < 1 || cols > maxDimension {
+		return nil, fmt.Errorf("cols out of range, must be 1...%d", maxDimension)
 	}
 	c := new(Client)
 	c.sequence = 1
 	c.rows, c.cols = rows, cols
 	c.network, c.address = network, address
+	if strings.IndexRune(c.address, ':') == -1 {
+		c.address += ":5078"
+	}
+	c.resolveInterval = DefaultResolveInterval
+	c.adjust = newAdjustment()
+	c.depth = newDepth()
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.depth.format == FormatMono {
+		// mono pixels have no channels to reorder, and their brightness must be judged with the channels in RGB order
+		c.adjust.order = OrderRGB
+	}
+	if err := c.adjust.prepare(); err != nil {
+		return nil, err
+	}
+	if c.protocol == ProtocolLegacy && rows > 256 {
+		return nil, fmt.Errorf("more than 256 rows needs ProtocolV1")
+	}
+	if c.protocol == ProtocolLegacy && c.delta {
+		return nil, fmt.Errorf("delta frames need ProtocolV1")
+	}
+	if c.protocol == ProtocolLegacy && c.ack.enabled() {
+		return nil, fmt.Errorf("acknowledgements need ProtocolV1")
+	}
+	if c.ack.timeout < 0 || c.ack.retries < 0 {
+		return nil, fmt.Errorf("ack timeout and retries must not be negative")
+	}
+	if c.protocol == ProtocolLegacy && c.compression != CompressionNone {
+		return nil, fmt.Errorf("compression needs ProtocolV1")
+	}
+	if c.compression < CompressionNone || c.compression > CompressionFlate {
+		return nil, fmt.Errorf("invalid compression %d", c.compression)
+	}
+	if err := c.depth.check(); err != nil {
+		return nil, err
+	}
+	if c.protocol == ProtocolLegacy && c.depth.format != FormatRGB888 {
+		return nil, fmt.Errorf("pixel formats other than RGB888 need ProtocolV1")
+	}
+	headerSize, rowNumberSize := c.protocol.sizes()
+	rowBytes := c.depth.format.rowBytes(cols)
+	rowSize := rowNumberSize + rowBytes
+	if c.compression == CompressionRLE {
+		// incompressible data grows by a count byte for every 128 bytes
+		rowSize += (rowBytes + 127) / 128
+	}
+	if c.mtu > 0 && c.mtu < headerSize+rowSize {
+		return nil, fmt.Errorf("mtu %d too small for a row of %d pixels", c.mtu, cols)
+	}
 	return c, nil
 }
 
+// Close releases the Client's socket and stops resending a frame waiting to be acknowledged.  The Client may still
+// be used, the next frame opens a new one.
 func (c *Client) Close() {
+	c.mutex.Lock()
+	c.stopAck()
+	c.disconnect()
+	c.mutex.Unlock()
+}
+
+// mutex MUST be locked when running this
+func (c *Client) disconnect() {
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
 }
 
+// Open the socket if needed, redialling if the panel's address has changed since it was last looked up
+// mutex MUST be locked when running this
 func (c *Client) connect() error {
-	if c.conn == nil {
-		var (
-			err  error
-			addr *net.UDPAddr
-		)
-		if strings.IndexRune(c.address, ':') == -1 {
-			c.address += ":5078"
+	if c.conn != nil && (c.resolveInterval <= 0 || time.Since(c.resolved) < c.resolveInterval) {
+		return nil
+	}
+	addr, err := net.ResolveUDPAddr(c.network, c.address)
+	if err != nil {
+		if c.conn != nil {
+			// keep using the address we have until the lookup works again
+			c.resolved = time.Now()
+			return nil
 		}
-		if addr, err = net.ResolveUDPAddr(c.network, c.address); err != nil {
-			return err
+		return err
+	}
+	c.resolved = time.Now()
+	if c.conn != nil {
+		if current, ok := c.conn.RemoteAddr().(*net.UDPAddr); ok && current.IP.Equal(addr.IP) && current.Port == addr.Port {
+			return nil
 		}
-		c.conn, err = net.DialUDP("udp", nil, addr)
+		c.disconnect()
+	}
+	if c.conn, err = net.DialUDP(c.network, nil, addr); err != nil {
 		return err
 	}
+	if c.ack.enabled() {
+		go c.readAcks(c.conn)
+	}
 	return nil
 }
 
+// SendImage sends img to the panel as the next frame.  If the send fails the address is looked up again and the
+// frame resent once.  With WithAck it returns without waiting for the acknowledgement, see Health.
 func (c *Client) SendImage(img image.Image) error {
-	err := c.connect()
-	if err != nil {
-		return err
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ack.pending != nil {
+		// the panel may not have the previous frame so this one must not depend on it
+		c.prev = nil
+	}
+	packets := c.createPackets(img)
+	if c.ack.enabled() {
+		// a frame which fails to send now is retried when its acknowledgement times out
+		c.expectAck(packets)
+	}
+	for _, buf := range packets {
+		if err := c.send(buf); err != nil {
+			// the panel may have missed rows a delta frame would not resend
+			c.prev = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// Send a packet, looking up the address again and resending once if it fails
+// mutex MUST be locked when running this
+func (c *Client) send(buf bytesbuffer.Buffer) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = c.connect(); err != nil {
+			continue
+		}
+		c.conn.SetWriteBuffer(buf.Size())
+		if _, err = c.conn.Write(buf.Bytes()); err == nil {
+			return nil
+		}
+		c.disconnect()
 	}
-	buf := c.createBuffer(img)
-	c.conn.SetWriteBuffer(buf.Size())
-	_, err = c.conn.Write(buf.Bytes())
 	return err
 }
 
-func (c *Client) createBuffer(img image.Image) bytesbuffer.Buffer {
+// Build the packets for a frame.  Each starts with the frame's sequence number, in a versioned header unless
+// the legacy protocol is used, followed by rows each prefixed by its row number, so a frame split across packets
+// needs no further reassembly information.
+// mutex MUST be locked when running this
+func (c *Client) createPackets(img image.Image) []bytesbuffer.Buffer {
 	s := img.Bounds()
-	buf, _ := bytesbuffer.NewBuffer(bytesbuffer.LittleEndian)
-	// Grow the buffer to max to avoid multiple memory allocation calls
-	rows := s.Max.Y - s.Min.Y
-	buf.Grow((rows)*(s.Max.X-s.Min.X)*3 + rows + 4)
-	buf.PutUint32(c.sequence)
-	c.sequence++
+	width, height := s.Max.X-s.Min.X, s.Max.Y-s.Min.Y
+	pix := make([]byte, 0, width*height*3)
+	rows := make([][]byte, 0, height)
 	for y := s.Min.Y; y < s.Max.Y; y++ {
-		buf.PutByte(byte(y))
-		for x := s.Min.X; x < s.Max.X; x++ {
-			p := img.At(x, y)
-			buf.PutByte(p.(color.RGBA).R)
-			buf.PutByte(p.(color.RGBA).G)
-			buf.PutByte(p.(color.RGBA).B)
+		start := len(pix)
+		pix = appendRow(pix, img, y)
+		c.adjust.apply(pix[start:])
+		rows = append(rows, pix[start:])
+	}
+	rows = c.depth.encode(rows, width)
+	send, keyframe := c.changedRows(rows)
+
+	// Pack the rows, each with its row number and compressed if need be, into packets no bigger than the MTU
+	headerSize, _ := c.protocol.sizes()
+	bodies := [][]byte{nil}
+	for _, y := range send {
+		var rec []byte
+		if c.protocol == ProtocolV1 {
+			rec = append(rec, byte(y), byte(y>>8))
+		} else {
+			rec = append(rec, byte(s.Min.Y+y))
+		}
+		if c.compression == CompressionRLE {
+			rec = packBits(rec, rows[y])
+		} else {
+			rec = append(rec, rows[y]...)
+		}
+		body := bodies[len(bodies)-1]
+		if c.mtu > 0 && len(body) > 0 && headerSize+len(body)+len(rec) > c.mtu {
+			bodies = append(bodies, nil)
+			body = nil
+		}
+		bodies[len(bodies)-1] = append(body, rec...)
+	}
+	h := header{
+		version:   Version1,
+		sequence:  c.sequence,
+		width:     uint16(width),
+		height:    uint16(height),
+		fragments: uint16(len(bodies)),
+	}
+	c.sequence++
+	// an unchanged frame is still sent, as a packet without rows, so the panel sees its sequence number
+	packets := make([]bytesbuffer.Buffer, 0, len(bodies))
+	for i, body := range bodies {
+		h.fragment = uint16(i)
+		h.flags = uint8(c.depth.format) << formatShift
+		if keyframe {
+			h.flags |= FlagKeyframe
+		}
+		if c.ack.enabled() {
+			h.flags |= FlagAck
+		}
+		switch c.compression {
+		case CompressionRLE:
+			h.flags |= FlagRLE
+		case CompressionFlate:
+			if z := deflate(body); len(z) < len(body) {
+				body = z
+				h.flags |= FlagFlate
+			}
+		}
+		buf, _ := bytesbuffer.NewBuffer(bytesbuffer.LittleEndian)
+		// Grow the buffer to max to avoid multiple memory allocation calls
+		buf.Grow(headerSize + len(body))
+		if c.protocol == ProtocolV1 {
+			h.put(&buf)
+		} else {
+			buf.PutUint32(h.sequence)
+		}
+		buf.Put(body)
+		packets = append(packets, buf)
+	}
+	return packets
+}
+
+// Choose which rows of a frame to send, all of them unless delta frames are enabled, and remember the frame to
+// compare the next one against.  Returns the indexes of the rows and whether the frame is a keyframe.
+// mutex MUST be locked when running this
+func (c *Client) changedRows(rows [][]byte) ([]int, bool) {
+	keyframe := !c.delta || len(c.prev) != len(rows) || c.sinceKeyframe >= c.keyframeInterval ||
+		(len(rows) > 0 && len(c.prev[0]) != len(rows[0]))
+	send := make([]int, 0, len(rows))
+	for y := range rows {
+		if keyframe || !bytes.Equal(rows[y], c.prev[y]) {
+			send = append(send, y)
+		}
+	}
+	if c.delta {
+		c.prev = rows
+		if keyframe {
+			c.sinceKeyframe = 0
 		}
+		c.sinceKeyframe++
 	}
-	return buf
+	return send, keyframe
 }
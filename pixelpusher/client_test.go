@@ -0,0 +1,211 @@
+package pixelpusher
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// Listen on a local UDP port, returning its address
+func listen(t *testing.T) (*net.UDPConn, string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, conn.LocalAddr().String()
+}
+
+// Receive a packet and return it
+func receive(t *testing.T, conn *net.UDPConn) []byte {
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestClient_sequence(t *testing.T) {
+	conn, addr := listen(t)
+	c, err := NewClient("udp", addr, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for want := uint32(1); want <= 3; want++ {
+		if err = c.SendImage(img); err != nil {
+			t.Fatal(err)
+		}
+		if seq := binary.LittleEndian.Uint32(receive(t, conn)); seq != want {
+			t.Errorf("frame sequence %d, want %d", seq, want)
+		}
+		// the socket is kept between frames, closing it only means a new one is opened
+		if want == 2 {
+			c.Close()
+		}
+	}
+}
+
+func TestClient_resolve(t *testing.T) {
+	_, addr := listen(t)
+	c, _ := NewClient("udp", addr, 2, 2, WithResolveInterval(time.Millisecond))
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	if err := c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	first := c.conn
+	time.Sleep(time.Millisecond * 2)
+	// the address is looked up again but is unchanged so the socket is kept
+	if err := c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	if c.conn != first {
+		t.Errorf("socket replaced although the address did not change")
+	}
+	// a panel moving to a new address is followed
+	conn2, addr2 := listen(t)
+	c.address = addr2
+	time.Sleep(time.Millisecond * 2)
+	if err := c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	if seq := binary.LittleEndian.Uint32(receive(t, conn2)); seq != 3 {
+		t.Errorf("frame at new address has sequence %d", seq)
+	}
+	if _, err := NewClient("udp", addr, 0, 2); err == nil {
+		t.Errorf("zero rows accepted")
+	}
+}
+
+func TestAppendRow(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rect := image.Rect(3, 2, 13, 7)
+	nrgba := image.NewNRGBA(rect)
+	r.Read(nrgba.Pix)
+	rgba := image.NewRGBA(rect)
+	draw.Draw(rgba, rect, nrgba, rect.Min, draw.Src)
+	paletted := image.NewPaletted(rect, palette.Plan9)
+	draw.Draw(paletted, rect, nrgba, rect.Min, draw.Src)
+	gray := image.NewGray(rect)
+	draw.Draw(gray, rect, nrgba, rect.Min, draw.Src)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for _, img := range []image.Image{nrgba, rgba, paletted, gray, ycbcr} {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			row := appendRow(nil, img, y)
+			if len(row) != rect.Dx()*3 {
+				t.Fatalf("%T row length %d", img, len(row))
+			}
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				want := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				if i := (x - rect.Min.X) * 3; row[i] != want.R || row[i+1] != want.G || row[i+2] != want.B {
+					t.Fatalf("%T pixel %d,%d is %v, want %v", img, x, y, row[i:i+3], want)
+				}
+			}
+		}
+	}
+}
+
+func TestClient_mtu(t *testing.T) {
+	conn, addr := listen(t)
+	c, err := NewClient("udp", addr, 32, 64, WithMTU(1400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i / (64 * 4))
+	}
+	if err = c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	// 1 row number and 64 pixels is 193 bytes so 7 rows fit in 1400 bytes with the sequence number
+	next := 0
+	for _, want := range []int{7, 7, 7, 7, 4} {
+		pkt := receive(t, conn)
+		if len(pkt) > 1400 || len(pkt) != 4+want*193 {
+			t.Fatalf("packet of %d bytes, want %d rows", len(pkt), want)
+		}
+		if seq := binary.LittleEndian.Uint32(pkt); seq != 1 {
+			t.Errorf("fragment sequence %d", seq)
+		}
+		for row := pkt[4:]; len(row) > 0; row = row[193:] {
+			if int(row[0]) != next || row[1] != byte(next) {
+				t.Errorf("row %d holds row %d", next, row[0])
+			}
+			next++
+		}
+	}
+	if _, err = NewClient("udp", addr, 32, 64, WithMTU(100)); err == nil {
+		t.Errorf("mtu smaller than a row accepted")
+	}
+}
+
+func TestClient_protocolV1(t *testing.T) {
+	conn, addr := listen(t)
+	c, err := NewClient("udp", addr, 300, 4, WithProtocol(ProtocolV1), WithMTU(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 300))
+	for y := 0; y < 300; y++ {
+		img.SetRGBA(0, y, color.RGBA{R: byte(y >> 8), G: byte(y), A: 0xff})
+	}
+	if err = c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	// 2 byte row number and 4 pixels is 14 bytes, 70 rows fit in 1000 bytes with the header
+	next := 0
+	for i, want := range []int{70, 70, 70, 70, 20} {
+		pkt := receive(t, conn)
+		h, err := parseHeader(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.sequence != 1 || h.width != 4 || h.height != 300 || int(h.fragment) != i || h.fragments != 5 {
+			t.Errorf("packet %d header %+v", i, h)
+		}
+		if len(pkt) != HeaderSize+want*14 {
+			t.Fatalf("packet of %d bytes, want %d rows", len(pkt), want)
+		}
+		for row := pkt[HeaderSize:]; len(row) > 0; row = row[14:] {
+			if y := int(binary.LittleEndian.Uint16(row)); y != next || row[2] != byte(y>>8) || row[3] != byte(y) {
+				t.Errorf("row %d holds row %d", next, y)
+			}
+			next++
+		}
+	}
+	if _, err = NewClient("udp", addr, 300, 4); err == nil {
+		t.Errorf("legacy protocol accepted more than 256 rows")
+	}
+}
+
+func benchmarkCreatePackets(b *testing.B, img image.Image) {
+	c, _ := NewClient("udp", "127.0.0.1", img.Bounds().Dy(), img.Bounds().Dx())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.createPackets(img)
+	}
+}
+
+func BenchmarkCreatePackets_64x32(b *testing.B) {
+	benchmarkCreatePackets(b, image.NewRGBA(image.Rect(0, 0, 64, 32)))
+}
+
+func BenchmarkCreatePackets_128x64(b *testing.B) {
+	benchmarkCreatePackets(b, image.NewRGBA(image.Rect(0, 0, 128, 64)))
+}
+
+func BenchmarkCreatePackets_paletted128x64(b *testing.B) {
+	benchmarkCreatePackets(b, image.NewPaletted(image.Rect(0, 0, 128, 64), palette.Plan9))
+}
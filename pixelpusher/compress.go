@@ -0,0 +1,101 @@
+package pixelpusher
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Compression selects how the rows in a packet are compressed.  Compressed packets are marked by a header flag,
+// so compression needs ProtocolV1.
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	// CompressionRLE run-length encodes each row, good for panels which are mostly a single color
+	CompressionRLE
+	// CompressionFlate deflates the rows of each packet, packets which would not shrink are sent uncompressed
+	CompressionFlate
+)
+
+// WithCompression compresses the rows of each packet.
+func WithCompression(compression Compression) Option {
+	return func(c *Client) {
+		c.compression = compression
+	}
+}
+
+// Append src to dst in PackBits form: a count byte n of 0...127 is followed by n+1 literal bytes, a count of
+// 129...255 by one byte repeated 257-n times.
+func packBits(dst, src []byte) []byte {
+	for len(src) > 0 {
+		run := 1
+		for run < len(src) && run < 128 && src[run] == src[0] {
+			run++
+		}
+		if run > 1 {
+			dst = append(dst, byte(257-run), src[0])
+			src = src[run:]
+			continue
+		}
+		// literals continue until the next run of at least 3 bytes
+		n := 1
+		for n < len(src) && n < 128 && !(n+2 < len(src) && src[n] == src[n+1] && src[n] == src[n+2]) {
+			n++
+		}
+		dst = append(dst, byte(n-1))
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+// Decode PackBits data from src until n bytes have been appended to dst, returning dst and the rest of src
+func unpackBits(dst, src []byte, n int) ([]byte, []byte, error) {
+	for end := len(dst) + n; len(dst) < end; {
+		if len(src) == 0 {
+			return dst, src, fmt.Errorf("run-length data ends early")
+		}
+		count := int(src[0])
+		switch {
+		case count < 128:
+			if len(src) < count+2 || len(dst)+count+1 > end {
+				return dst, src, fmt.Errorf("bad run-length literal")
+			}
+			dst = append(dst, src[1:count+2]...)
+			src = src[count+2:]
+		case count > 128:
+			if len(src) < 2 || len(dst)+257-count > end {
+				return dst, src, fmt.Errorf("bad run-length run")
+			}
+			for i := 0; i < 257-count; i++ {
+				dst = append(dst, src[1])
+			}
+			src = src[2:]
+		default:
+			src = src[1:]
+		}
+	}
+	return dst, src, nil
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Inflate data, which must not expand to more than limit bytes
+func inflate(data []byte, limit int) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("compressed rows expand beyond %d bytes", limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package pixelpusher
+
+import (
+	"bytes"
+	"github.com/jjcinaz/panelserver/bytesbuffer"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestPackBits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cases := [][]byte{
+		{},
+		{7},
+		bytes.Repeat([]byte{0}, 300),
+		[]byte("abcabcabc"),
+		append(append([]byte("xy"), bytes.Repeat([]byte{1}, 129)...), 'z'),
+	}
+	for i := 0; i < 100; i++ {
+		// random data with runs in it
+		var b []byte
+		for len(b) < 400 {
+			b = append(b, bytes.Repeat([]byte{byte(r.Intn(3))}, r.Intn(10)+1)...)
+		}
+		cases = append(cases, b)
+	}
+	for _, src := range cases {
+		packed := packBits(nil, src)
+		if len(packed) > len(src)+(len(src)+127)/128 {
+			t.Errorf("%d bytes packed to %d", len(src), len(packed))
+		}
+		got, rest, err := unpackBits([]byte{0xee}, append(packed, 0xff), len(src))
+		if err != nil || !bytes.Equal(got[1:], src) || len(rest) != 1 {
+			t.Fatalf("round trip of %v gave %v, %v rest %v", src, got, err, rest)
+		}
+	}
+	if _, _, err := unpackBits(nil, []byte{5, 1, 2}, 6); err == nil {
+		t.Errorf("truncated literal accepted")
+	}
+}
+
+// Mostly black with some white "text"
+func textFrame() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 128; x++ {
+			if y%16 > 2 && y%16 < 12 && x%8 < 5 && (x*y)%3 == 0 {
+				img.SetRGBA(x, y, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{A: 0xff})
+			}
+		}
+	}
+	return img
+}
+
+func TestClient_compression(t *testing.T) {
+	img := textFrame()
+	plain, _ := NewClient("udp", "127.0.0.1", 64, 128, WithProtocol(ProtocolV1))
+	raw := 0
+	for _, buf := range plain.createPackets(img) {
+		raw += buf.Size()
+	}
+	for _, compression := range []Compression{CompressionRLE, CompressionFlate} {
+		for _, mtu := range []int{0, 1400} {
+			c, err := NewClient("udp", "127.0.0.1", 64, 128, WithProtocol(ProtocolV1), WithCompression(compression), WithMTU(mtu))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewDecoder(ProtocolV1, 0, 0)
+			size := 0
+			for _, buf := range c.createPackets(img) {
+				if mtu > 0 && buf.Size() > mtu {
+					t.Errorf("compression %d packet of %d bytes", compression, buf.Size())
+				}
+				size += buf.Size()
+				if _, err = d.Decode(buf.Bytes()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sameImage(t, d.Image(), img)
+			if size*3 > raw {
+				t.Errorf("compression %d mtu %d only reduced %d bytes to %d", compression, mtu, raw, size)
+			}
+		}
+	}
+	if _, err := NewClient("udp", "127.0.0.1", 64, 128, WithCompression(CompressionRLE)); err == nil {
+		t.Errorf("compression accepted with legacy protocol")
+	}
+}
+
+func TestClient_incompressible(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	c, _ := NewClient("udp", "127.0.0.1", 32, 64, WithProtocol(ProtocolV1), WithCompression(CompressionFlate), WithMTU(1400))
+	d := NewDecoder(ProtocolV1, 0, 0)
+	for _, buf := range c.createPackets(img) {
+		if buf.Bytes()[3]&FlagFlate != 0 {
+			t.Errorf("random data sent deflated")
+		}
+		if _, err := d.Decode(buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sameImage(t, d.Image(), img)
+}
+
+func TestDecoder_inflateLimit(t *testing.T) {
+	// a few hundred bytes which inflate to a megabyte, far more than a 2x2 frame holds
+	bomb := deflate(make([]byte, 1<<20))
+	buf, _ := bytesbuffer.NewBuffer(bytesbuffer.LittleEndian)
+	h := header{version: Version1, flags: FlagKeyframe | FlagFlate, sequence: 1, width: 2, height: 2, fragments: 1}
+	h.put(&buf)
+	buf.Put(bomb)
+	if _, err := NewDecoder(ProtocolV1, 0, 0).Decode(buf.Bytes()); err == nil {
+		t.Errorf("%d bytes inflating to 1MB accepted", len(bomb))
+	}
+	// exactly a frame's rows are fine
+	rows := make([]byte, 2*(2+6))
+	rows[8] = 1
+	if out, err := inflate(deflate(rows), len(rows)); err != nil || !bytes.Equal(out, rows) {
+		t.Errorf("inflate of a whole frame: %v", err)
+	}
+}
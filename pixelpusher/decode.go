@@ -0,0 +1,204 @@
+package pixelpusher
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// ErrStale is returned by Decoder.Decode for a packet of a frame older than the one being assembled
+var ErrStale = errors.New("stale packet")
+
+// A sequence further back than this from the frame being assembled is taken to come from a restarted sender
+const resyncDistance = 256
+
+// Frame describes the frame a decoded packet belonged to
+type Frame struct {
+	Sequence uint32
+	Keyframe bool // the frame holds every row, always true for ProtocolLegacy
+	Complete bool // every packet of the frame has now been received, or for ProtocolLegacy every row
+	Ack      bool // the sender asked for the frame to be acknowledged, see AppendAck
+}
+
+// Decoder rebuilds a panel's image from the packets sent by a Client, as a panel would.  Rows are drawn as soon
+// as they arrive, so after a lost packet the image may be out of date until the next keyframe.  A Decoder is not
+// safe for concurrent use.
+type Decoder struct {
+	protocol Protocol
+	img      *image.RGBA
+	sequence uint32 // frame being assembled
+	started  bool
+	received []bool // fragments of the frame received
+	synced   bool
+	palette  [2]color.RGBA
+	line     []byte
+}
+
+// NewDecoder returns a Decoder for packets in the given protocol.  rows and cols give the panel's size, which for
+// ProtocolV1 is replaced by the size in each frame's header.
+func NewDecoder(protocol Protocol, rows, cols int) *Decoder {
+	return &Decoder{
+		protocol: protocol,
+		img:      image.NewRGBA(image.Rect(0, 0, cols, rows)),
+		palette:  newDepth().palette,
+	}
+}
+
+// SetPalette sets the colors FormatMono pixels are drawn in, black and white by default.
+func (d *Decoder) SetPalette(off, on color.Color) {
+	d.palette = [2]color.RGBA{toRGBA(off), toRGBA(on)}
+}
+
+// Image returns the panel's current contents.  It is updated in place by Decode.
+func (d *Decoder) Image() *image.RGBA {
+	return d.img
+}
+
+// Synced reports whether every packet since the last keyframe has been received, so that Image shows exactly
+// what was sent.
+func (d *Decoder) Synced() bool {
+	return d.synced
+}
+
+// Decode draws the rows in pkt onto the image and returns the frame the packet belonged to.
+func (d *Decoder) Decode(pkt []byte) (Frame, error) {
+	if d.protocol == ProtocolV1 {
+		return d.decodeV1(pkt)
+	}
+	return d.decodeLegacy(pkt)
+}
+
+func (d *Decoder) decodeLegacy(pkt []byte) (Frame, error) {
+	cols := d.img.Rect.Dx()
+	if len(pkt) < 4 || (len(pkt)-4)%(1+cols*3) != 0 {
+		return Frame{}, fmt.Errorf("packet length %d does not hold whole rows of %d pixels", len(pkt), cols)
+	}
+	f := Frame{Sequence: binary.LittleEndian.Uint32(pkt), Keyframe: true}
+	if err := d.checkSequence(f); err != nil {
+		return f, err
+	}
+	if !d.started || f.Sequence != d.sequence {
+		// a legacy frame split across packets has no fragment count, it is whole once its rows cover the image
+		d.started, d.sequence = true, f.Sequence
+		d.received = make([]bool, d.img.Rect.Dy())
+		d.synced = false
+	}
+	for rows := pkt[4:]; len(rows) > 0; rows = rows[1+cols*3:] {
+		y := int(rows[0])
+		if y >= d.img.Rect.Dy() {
+			return f, fmt.Errorf("row %d outside %d row panel", y, d.img.Rect.Dy())
+		}
+		d.drawRow(y, rows[1:1+cols*3])
+		d.received[y] = true
+	}
+	if f.Complete = d.receivedAll(); f.Complete {
+		d.synced = true
+	}
+	return f, nil
+}
+
+func (d *Decoder) decodeV1(pkt []byte) (Frame, error) {
+	h, err := parseHeader(pkt)
+	if err != nil {
+		return Frame{}, err
+	}
+	f := Frame{Sequence: h.sequence, Keyframe: h.flags&FlagKeyframe != 0, Ack: h.flags&FlagAck != 0}
+	if h.width < 1 || h.width > maxDimension || h.height < 1 || h.height > maxDimension {
+		// refuse before the image is resized to suit
+		return f, fmt.Errorf("frame of %dx%d pixels out of range, must be 1...%d", h.width, h.height, maxDimension)
+	}
+	body := pkt[HeaderSize:]
+	format := PixelFormat(h.flags >> formatShift)
+	if format > FormatMono {
+		return f, fmt.Errorf("unknown pixel format %d", format)
+	}
+	rowSize := 2 + format.rowBytes(int(h.width))
+	if h.flags&FlagFlate != 0 {
+		// a packet holds at most every row of the frame
+		if body, err = inflate(body, int(h.height)*rowSize); err != nil {
+			return f, err
+		}
+	}
+	if h.flags&FlagRLE != 0 {
+		// expand the rows so they can be drawn the same way as uncompressed ones
+		var rows []byte
+		for len(body) > 0 {
+			if len(body) < 2 {
+				return f, fmt.Errorf("truncated row")
+			}
+			rows = append(rows, body[:2]...)
+			if rows, body, err = unpackBits(rows, body[2:], rowSize-2); err != nil {
+				return f, err
+			}
+		}
+		body = rows
+	}
+	if len(body)%rowSize != 0 {
+		return f, fmt.Errorf("packet does not hold whole rows of %d pixels", h.width)
+	}
+	if err = d.checkSequence(f); err != nil {
+		return f, err
+	}
+	if !d.started || f.Sequence != d.sequence {
+		if d.started && (f.Sequence != d.sequence+1 || !d.receivedAll()) {
+			// frames or packets were lost
+			d.synced = false
+		}
+		d.started, d.sequence = true, f.Sequence
+		d.received = make([]bool, h.fragments)
+		if d.img.Rect.Dx() != int(h.width) || d.img.Rect.Dy() != int(h.height) {
+			d.img = image.NewRGBA(image.Rect(0, 0, int(h.width), int(h.height)))
+			d.synced = false
+		}
+	}
+	if int(h.fragments) != len(d.received) || int(h.width) != d.img.Rect.Dx() {
+		return f, fmt.Errorf("packet of frame %d disagrees with its first packet", f.Sequence)
+	}
+	for rows := body; len(rows) > 0; rows = rows[rowSize:] {
+		y := int(binary.LittleEndian.Uint16(rows))
+		if y >= d.img.Rect.Dy() {
+			return f, fmt.Errorf("row %d outside %d row frame", y, d.img.Rect.Dy())
+		}
+		d.line = format.decodeRow(d.line[:0], rows[2:rowSize], int(h.width), d.palette)
+		d.drawRow(y, d.line)
+	}
+	d.received[h.fragment] = true
+	if f.Complete = d.receivedAll(); f.Complete && f.Keyframe {
+		d.synced = true
+	}
+	return f, nil
+}
+
+// Return ErrStale for a packet of a frame older than the one being assembled, unless the sender has restarted:
+// a Client starts again from sequence 1 with a keyframe, anything else which jumps far back is also taken as a
+// restart.  After a restart the Decoder starts over with the packet's frame.
+func (d *Decoder) checkSequence(f Frame) error {
+	if !d.started || int32(f.Sequence-d.sequence) >= 0 {
+		return nil
+	}
+	if (f.Sequence == 1 && f.Keyframe) || d.sequence-f.Sequence > resyncDistance {
+		d.started, d.synced = false, false
+		return nil
+	}
+	return ErrStale
+}
+
+func (d *Decoder) receivedAll() bool {
+	for _, ok := range d.received {
+		if !ok {
+			return false
+		}
+	}
+	return len(d.received) > 0
+}
+
+// Draw a row of RGB pixels
+func (d *Decoder) drawRow(y int, rgb []byte) {
+	pix := d.img.Pix[d.img.PixOffset(0, y):]
+	for i := 0; i+2 < len(rgb); i += 3 {
+		pix[0], pix[1], pix[2], pix[3] = rgb[i], rgb[i+1], rgb[i+2], 0xff
+		pix = pix[4:]
+	}
+}
@@ -0,0 +1,146 @@
+package pixelpusher
+
+import (
+	"bytes"
+	"github.com/jjcinaz/panelserver/bytesbuffer"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Encode frames with c and decode them with d, returning the frame info of each packet
+func roundTrip(t *testing.T, c *Client, d *Decoder, img image.Image) []Frame {
+	var frames []Frame
+	for _, buf := range c.createPackets(img) {
+		f, err := d.Decode(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func sameImage(t *testing.T, got *image.RGBA, want image.Image) {
+	t.Helper()
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			w.A = 0xff
+			if g := got.RGBAAt(x-b.Min.X, y-b.Min.Y); g != w {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+// A 64x32 test frame with a moving "clock hand"
+func testFrame(n int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: byte(x * 4), G: byte(y * 8), B: 0x40, A: 0xff})
+		}
+	}
+	img.SetRGBA(n%64, n%32, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+	return img
+}
+
+func TestDecoder_legacy(t *testing.T) {
+	c, _ := NewClient("udp", "127.0.0.1", 32, 64, WithMTU(1400))
+	d := NewDecoder(ProtocolLegacy, 32, 64)
+	img := testFrame(0)
+	frames := roundTrip(t, c, d, img)
+	if len(frames) != 5 || !frames[4].Complete || frames[3].Complete || !d.Synced() {
+		t.Errorf("frames %+v", frames)
+	}
+	sameImage(t, d.Image(), img)
+}
+
+func TestDecoder_delta(t *testing.T) {
+	c, _ := NewClient("udp", "127.0.0.1", 32, 64, WithProtocol(ProtocolV1), WithDelta(4), WithMTU(1400))
+	d := NewDecoder(ProtocolV1, 0, 0)
+	var sizes []int
+	for n := 0; n < 6; n++ {
+		img := testFrame(n)
+		size := 0
+		for _, buf := range c.createPackets(img) {
+			size += buf.Size()
+			f, err := d.Decode(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := n%4 == 0; f.Keyframe != want {
+				t.Errorf("frame %d keyframe %v", n, f.Keyframe)
+			}
+		}
+		sizes = append(sizes, size)
+		if !d.Synced() {
+			t.Errorf("decoder not synced after frame %d", n)
+		}
+		sameImage(t, d.Image(), img)
+	}
+	// a delta frame only carries the rows the hand moved from and to
+	if sizes[1] != HeaderSize+2*(2+64*3) || sizes[4] != sizes[0] {
+		t.Errorf("frame sizes %v", sizes)
+	}
+	// an unchanged frame is just a header
+	if pkts := c.createPackets(testFrame(5)); len(pkts) != 1 || pkts[0].Size() != HeaderSize {
+		t.Errorf("unchanged frame sent %d packets", len(pkts))
+	}
+}
+
+func TestDecoder_lost(t *testing.T) {
+	c, _ := NewClient("udp", "127.0.0.1", 32, 64, WithProtocol(ProtocolV1), WithDelta(3))
+	d := NewDecoder(ProtocolV1, 0, 0)
+	roundTrip(t, c, d, testFrame(0))
+	c.createPackets(testFrame(1)) // lost
+	old := c.createPackets(testFrame(2))
+	if _, err := d.Decode(old[0].Bytes()); err != nil || d.Synced() {
+		t.Errorf("decoder synced after a lost delta frame, %v", err)
+	}
+	// the keyframe restores the image
+	roundTrip(t, c, d, testFrame(3))
+	if !d.Synced() {
+		t.Errorf("decoder not synced after keyframe")
+	}
+	sameImage(t, d.Image(), testFrame(3))
+	if _, err := d.Decode(old[0].Bytes()); err != ErrStale {
+		t.Errorf("old packet decoded with %v", err)
+	}
+	if _, err := d.Decode(bytes.Repeat([]byte{0}, 20)); err == nil {
+		t.Errorf("garbage decoded")
+	}
+}
+
+func TestDecoder_restart(t *testing.T) {
+	c, _ := NewClient("udp", "127.0.0.1", 32, 64, WithProtocol(ProtocolV1), WithDelta(0))
+	d := NewDecoder(ProtocolV1, 0, 0)
+	for i := 0; i < 5; i++ {
+		roundTrip(t, c, d, testFrame(i))
+	}
+	// a restarted sender begins again at sequence 1
+	c, _ = NewClient("udp", "127.0.0.1", 32, 64, WithProtocol(ProtocolV1), WithDelta(0))
+	if f := roundTrip(t, c, d, testFrame(7)); f[0].Sequence != 1 || !d.Synced() {
+		t.Errorf("restarted sender's frame %+v not decoded", f)
+	}
+	sameImage(t, d.Image(), testFrame(7))
+	roundTrip(t, c, d, testFrame(8))
+	sameImage(t, d.Image(), testFrame(8))
+}
+
+func TestDecoder_size(t *testing.T) {
+	d := NewDecoder(ProtocolV1, 32, 64)
+	for _, size := range [][2]uint16{{65535, 65535}, {0, 32}, {64, 1025}} {
+		buf, _ := bytesbuffer.NewBuffer(bytesbuffer.LittleEndian)
+		h := header{version: Version1, flags: FlagKeyframe, sequence: 1, width: size[0], height: size[1], fragments: 1}
+		h.put(&buf)
+		if _, err := d.Decode(buf.Bytes()); err == nil {
+			t.Errorf("%dx%d frame accepted", size[0], size[1])
+		}
+	}
+	if b := d.Image().Rect; b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("image resized to %v", b)
+	}
+}
@@ -0,0 +1,219 @@
+package pixelpusher
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// PixelFormat is the encoding of each pixel in a row.  Formats other than FormatRGB888 need ProtocolV1, the format
+// is carried in the top 4 bits of the header flags.
+type PixelFormat int
+
+const (
+	// FormatRGB888 sends 3 bytes per pixel in the Client's color order
+	FormatRGB888 PixelFormat = iota
+	// FormatRGB565 sends a little endian uint16 per pixel, 5 bits of the first channel in the top bits, 6 bits of
+	// the second and 5 of the third
+	FormatRGB565
+	// FormatRGB332 sends a byte per pixel, 3 bits of the first channel in the top bits, 3 of the second and 2 of
+	// the third
+	FormatRGB332
+	// FormatMono sends a bit per pixel, the leftmost pixel in the top bit of each byte, choosing between two
+	// palette colors (see WithPalette).  WithColorOrder has no effect.
+	FormatMono
+)
+
+// Dither selects how the error from reducing pixels to fewer colors is spread out
+type Dither int
+
+const (
+	DitherNone Dither = iota
+	// DitherOrdered adds a 4x4 Bayer threshold pattern, which keeps unchanged areas stable between frames
+	DitherOrdered
+	// DitherFloydSteinberg diffuses each pixel's error onto its neighbours
+	DitherFloydSteinberg
+)
+
+// Shift of the PixelFormat within the header flags
+const formatShift = 4
+
+// Bits of each channel in the formats with reduced color depth
+var formatBits = [...][]int{
+	FormatRGB888: {8, 8, 8},
+	FormatRGB565: {5, 6, 5},
+	FormatRGB332: {3, 3, 2},
+	FormatMono:   {1},
+}
+
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// WithPixelFormat sets the encoding of pixels, FormatRGB888 unless given.
+func WithPixelFormat(format PixelFormat) Option {
+	return func(c *Client) {
+		c.depth.format = format
+	}
+}
+
+// WithDither sets how colors are dithered when WithPixelFormat reduces the color depth.
+func WithDither(dither Dither) Option {
+	return func(c *Client) {
+		c.depth.dither = dither
+	}
+}
+
+// WithPalette sets the colors of the unlit and lit pixels for FormatMono, black and white unless given.  Each
+// pixel is sent as whichever its brightness is closer to.
+func WithPalette(off, on color.Color) Option {
+	return func(c *Client) {
+		c.depth.palette = [2]color.RGBA{toRGBA(off), toRGBA(on)}
+	}
+}
+
+func toRGBA(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+// Pixel encoding of a Client
+type depth struct {
+	format  PixelFormat
+	dither  Dither
+	palette [2]color.RGBA
+}
+
+func newDepth() depth {
+	return depth{palette: [2]color.RGBA{{A: 0xff}, {R: 0xff, G: 0xff, B: 0xff, A: 0xff}}}
+}
+
+func (d *depth) check() error {
+	if d.format < FormatRGB888 || d.format > FormatMono {
+		return fmt.Errorf("invalid pixel format %d", d.format)
+	}
+	if d.dither < DitherNone || d.dither > DitherFloydSteinberg {
+		return fmt.Errorf("invalid dither %d", d.dither)
+	}
+	if luma(d.palette[0]) == luma(d.palette[1]) {
+		return fmt.Errorf("palette colors must differ in brightness")
+	}
+	return nil
+}
+
+// Return the length of a row of width pixels
+func (f PixelFormat) rowBytes(width int) int {
+	switch f {
+	case FormatRGB565:
+		return width * 2
+	case FormatRGB332:
+		return width
+	case FormatMono:
+		return (width + 7) / 8
+	}
+	return width * 3
+}
+
+func luma(c color.RGBA) float64 {
+	return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+}
+
+// Reduce rows of RGB pixels to the pixel format, returning the encoded rows.  Rows of FormatRGB888 are returned
+// unchanged.
+func (d *depth) encode(rows [][]byte, width int) [][]byte {
+	if d.format == FormatRGB888 {
+		return rows
+	}
+	bits := formatBits[d.format]
+	channels := len(bits)
+	// channel values of the current and next row, carrying Floyd-Steinberg error
+	cur, next := make([]float64, width*channels), make([]float64, width*channels)
+	lo, hi := luma(d.palette[0]), luma(d.palette[1])
+	load := func(dst []float64, row []byte) {
+		for x := 0; x < width; x++ {
+			if d.format == FormatMono {
+				px := color.RGBA{R: row[x*3], G: row[x*3+1], B: row[x*3+2]}
+				dst[x] += (luma(px) - lo) / (hi - lo) * 255
+			} else {
+				for ch := 0; ch < 3; ch++ {
+					dst[x*3+ch] += float64(row[x*3+ch])
+				}
+			}
+		}
+	}
+	encoded := make([][]byte, len(rows))
+	levels := make([]int, channels)
+	for y := range rows {
+		if y == 0 {
+			load(cur, rows[0])
+		}
+		for i := range next {
+			next[i] = 0
+		}
+		if y+1 < len(rows) {
+			load(next, rows[y+1])
+		}
+		out := make([]byte, d.format.rowBytes(width))
+		for x := 0; x < width; x++ {
+			for ch, n := range bits {
+				max := float64(int(1)<<uint(n) - 1)
+				v := cur[x*channels+ch]
+				if d.dither == DitherOrdered {
+					v += (bayer4[y%4][x%4]/16 - 0.5 + 1.0/32) * 255 / max
+				}
+				q := math.Round(math.Max(0, math.Min(255, v)) * max / 255)
+				levels[ch] = int(q)
+				if d.dither == DitherFloydSteinberg {
+					e := v - q*255/max
+					if x+1 < width {
+						cur[(x+1)*channels+ch] += e * 7 / 16
+						next[(x+1)*channels+ch] += e * 1 / 16
+					}
+					if x > 0 {
+						next[(x-1)*channels+ch] += e * 3 / 16
+					}
+					next[x*channels+ch] += e * 5 / 16
+				}
+			}
+			switch d.format {
+			case FormatRGB565:
+				v := uint16(levels[0]<<11 | levels[1]<<5 | levels[2])
+				out[x*2], out[x*2+1] = byte(v), byte(v>>8)
+			case FormatRGB332:
+				out[x] = byte(levels[0]<<5 | levels[1]<<2 | levels[2])
+			case FormatMono:
+				if levels[0] != 0 {
+					out[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+		}
+		encoded[y] = out
+		cur, next = next, cur
+	}
+	return encoded
+}
+
+// Expand a row in format back to RGB pixels, appending them to dst
+func (f PixelFormat) decodeRow(dst, row []byte, width int, palette [2]color.RGBA) []byte {
+	scale := func(v, bits int) byte {
+		return byte(v * 255 / (1<<uint(bits) - 1))
+	}
+	for x := 0; x < width; x++ {
+		switch f {
+		case FormatRGB565:
+			v := int(row[x*2]) | int(row[x*2+1])<<8
+			dst = append(dst, scale(v>>11, 5), scale(v>>5&0x3f, 6), scale(v&0x1f, 5))
+		case FormatRGB332:
+			v := int(row[x])
+			dst = append(dst, scale(v>>5, 3), scale(v>>2&7, 3), scale(v&3, 2))
+		case FormatMono:
+			p := palette[row[x/8]>>uint(7-x%8)&1]
+			dst = append(dst, p.R, p.G, p.B)
+		default:
+			dst = append(dst, row[x*3:x*3+3]...)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,113 @@
+package pixelpusher
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// Send img through a client with opts and decode it
+func decodeWith(t *testing.T, img image.Image, opts ...Option) (*Decoder, int) {
+	b := img.Bounds()
+	c, err := NewClient("udp", "127.0.0.1", b.Dy(), b.Dx(), append([]Option{WithProtocol(ProtocolV1)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(ProtocolV1, 0, 0)
+	size := 0
+	for _, buf := range c.createPackets(img) {
+		size += buf.Size() - HeaderSize
+		if _, err = d.Decode(buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d, size
+}
+
+func flat(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// Mean value of a channel over the image
+func mean(img *image.RGBA, ch int) float64 {
+	sum := 0.0
+	for i := ch; i < len(img.Pix); i += 4 {
+		sum += float64(img.Pix[i])
+	}
+	return sum / float64(len(img.Pix)/4)
+}
+
+func TestPixelFormat_sizes(t *testing.T) {
+	img := textFrame()
+	_, full := decodeWith(t, img)
+	for format, shrink := range map[PixelFormat]float64{FormatRGB565: 1.5, FormatRGB332: 3, FormatMono: 24} {
+		_, size := decodeWith(t, img, WithPixelFormat(format))
+		// each row also carries its 2 byte row number
+		rows := 64 * 2
+		if got := float64(full-rows) / float64(size-rows); got != shrink {
+			t.Errorf("format %d shrinks frames %vx, want %vx", format, got, shrink)
+		}
+	}
+}
+
+func TestPixelFormat_exact(t *testing.T) {
+	img := textFrame()
+	img.SetRGBA(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	img.SetRGBA(1, 0, color.RGBA{G: 0xff, B: 0xff, A: 0xff})
+	for _, format := range []PixelFormat{FormatRGB565, FormatRGB332} {
+		d, _ := decodeWith(t, img, WithPixelFormat(format), WithCompression(CompressionRLE))
+		sameImage(t, d.Image(), img)
+	}
+	// mono pixels take the nearer palette color
+	amber := color.RGBA{R: 0xff, G: 0xbf, A: 0xff}
+	mono := textFrame()
+	mono.SetRGBA(5, 5, color.RGBA{R: 0xa0, G: 0xa0, B: 0xa0, A: 0xff})
+	mono.SetRGBA(6, 5, color.RGBA{R: 0x50, G: 0x50, B: 0x50, A: 0xff})
+	c, _ := NewClient("udp", "127.0.0.1", 64, 128, WithProtocol(ProtocolV1), WithPixelFormat(FormatMono), WithPalette(color.Black, amber))
+	d := NewDecoder(ProtocolV1, 0, 0)
+	d.SetPalette(color.Black, amber)
+	roundTrip(t, c, d, mono)
+	if d.Image().RGBAAt(5, 5) != amber || d.Image().RGBAAt(6, 5) != (color.RGBA{A: 0xff}) {
+		t.Errorf("mono pixels %v %v", d.Image().RGBAAt(5, 5), d.Image().RGBAAt(6, 5))
+	}
+}
+
+func TestPixelFormat_dither(t *testing.T) {
+	gray := color.RGBA{R: 100, G: 100, B: 100, A: 0xff}
+	for _, format := range []PixelFormat{FormatRGB332, FormatMono} {
+		plain, _ := decodeWith(t, flat(gray), WithPixelFormat(format))
+		for _, dither := range []Dither{DitherOrdered, DitherFloydSteinberg} {
+			d, _ := decodeWith(t, flat(gray), WithPixelFormat(format), WithDither(dither))
+			// dithering keeps the average close to the original where plain quantizing cannot, to within the 17
+			// levels of the Bayer matrix for ordered dithering
+			if m := mean(d.Image(), 0); math.Abs(m-100) > 5 {
+				t.Errorf("format %d dither %d mean %.1f", format, dither, m)
+			}
+			if m := mean(plain.Image(), 0); math.Abs(m-100) < 5 {
+				t.Errorf("format %d undithered mean %.1f unexpectedly close", format, m)
+			}
+		}
+	}
+	if _, err := NewClient("udp", "127.0.0.1", 32, 64, WithPixelFormat(FormatMono)); err == nil {
+		t.Errorf("mono accepted with legacy protocol")
+	}
+	if _, err := NewClient("udp", "127.0.0.1", 32, 64, WithProtocol(ProtocolV1), WithPalette(color.White, color.White)); err == nil {
+		t.Errorf("palette of one color accepted")
+	}
+}
+
+func TestPixelFormat_monoOrder(t *testing.T) {
+	// orange is lit by its brightness however the panel orders its channels
+	img := flat(color.RGBA{R: 255, G: 128, A: 0xff})
+	for _, order := range []ColorOrder{OrderRGB, OrderBGR, OrderGBR} {
+		d, _ := decodeWith(t, img, WithPixelFormat(FormatMono), WithColorOrder(order))
+		if m := mean(d.Image(), 0); m != 255 {
+			t.Errorf("order %d lights %.0f%% of pixels", order, m/255*100)
+		}
+	}
+}
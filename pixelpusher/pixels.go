@@ -0,0 +1,41 @@
+package pixelpusher
+
+import (
+	"image"
+	"image/color"
+)
+
+// Append row y of img to dst as 8 bit R, G, B triples.  Colors are converted through color.RGBAModel, so images
+// with transparency are sent as if drawn over black.  *image.RGBA and *image.NRGBA are read directly from their
+// pixel slices, any other image goes through At.
+func appendRow(dst []byte, img image.Image, y int) []byte {
+	b := img.Bounds()
+	switch img := img.(type) {
+	case *image.RGBA:
+		pix := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(pix); i += 4 {
+			dst = append(dst, pix[i], pix[i+1], pix[i+2])
+		}
+	case *image.NRGBA:
+		pix := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(pix); i += 4 {
+			if a := pix[i+3]; a == 0xff {
+				dst = append(dst, pix[i], pix[i+1], pix[i+2])
+			} else {
+				dst = append(dst, premultiply(pix[i], a), premultiply(pix[i+1], a), premultiply(pix[i+2], a))
+			}
+		}
+	default:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			dst = append(dst, p.R, p.G, p.B)
+		}
+	}
+	return dst
+}
+
+// Scale a non-premultiplied channel by alpha exactly as color.NRGBA.RGBA followed by color.RGBAModel does
+func premultiply(v, a byte) byte {
+	v16, a16 := uint32(v)|uint32(v)<<8, uint32(a)|uint32(a)<<8
+	return byte(v16 * a16 / 0xffff >> 8)
+}
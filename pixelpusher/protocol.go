@@ -0,0 +1,127 @@
+package pixelpusher
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/jjcinaz/panelserver/bytesbuffer"
+)
+
+// Protocol selects the packet format spoken to the panel
+type Protocol int
+
+const (
+	// ProtocolLegacy is the original format understood by all panels: a 32 bit sequence number followed by rows,
+	// each prefixed by an 8 bit row number.  It cannot address more than 256 rows.
+	ProtocolLegacy Protocol = iota
+	// ProtocolV1 starts each packet with a versioned header and prefixes rows with a 16 bit row number.
+	ProtocolV1
+)
+
+// Magic starts every packet with a versioned header.  A legacy packet can begin with the same bytes, so receivers
+// which accept both formats should also check the version and packet length.
+var Magic = [2]byte{'P', 'X'}
+
+// Version1 is the header version of ProtocolV1
+const Version1 = 1
+
+// HeaderSize is the length of a versioned header
+const HeaderSize = 16
+
+// Header flags
+const (
+	// FlagKeyframe is set on every packet of a frame which holds all of its rows
+	FlagKeyframe = 1 << iota
+	// FlagRLE marks a packet whose rows are run-length encoded
+	FlagRLE
+	// FlagFlate marks a packet whose rows are compressed with deflate
+	FlagFlate
+	// FlagAck asks the receiver to acknowledge the frame once all its packets have arrived, and marks the
+	// acknowledgement
+	FlagAck
+)
+
+// A versioned packet header, all fields are little endian:
+//
+//	magic     [2]byte  "PX"
+//	version   uint8    1
+//	flags     uint8    see FlagKeyframe, FlagRLE, FlagFlate and FlagAck, the top 4 bits are the PixelFormat
+//	sequence  uint32   frame sequence number
+//	width     uint16   columns in the frame
+//	height    uint16   rows in the frame
+//	fragment  uint16   index of this packet within the frame
+//	fragments uint16   number of packets making up the frame
+//
+// The header is followed by rows, each a uint16 row number and width pixels.  A keyframe holds every row of
+// the frame, otherwise the frame holds only the rows which changed since the previous frame and may hold none.
+// With FlagRLE each row's pixels are PackBits encoded; with FlagFlate everything after the header is deflated.
+//
+// A frame sent with FlagAck is acknowledged by the receiver sending back a header alone, with FlagAck set, the
+// sequence of the frame, a width and height of zero and a single fragment.
+type header struct {
+	version             uint8
+	flags               uint8
+	sequence            uint32
+	width, height       uint16
+	fragment, fragments uint16
+}
+
+func (h *header) put(buf *bytesbuffer.Buffer) {
+	buf.Put(Magic[:])
+	buf.PutByte(h.version)
+	buf.PutByte(h.flags)
+	buf.PutUint32(h.sequence)
+	buf.PutUint16(h.width)
+	buf.PutUint16(h.height)
+	buf.PutUint16(h.fragment)
+	buf.PutUint16(h.fragments)
+}
+
+// Parse the versioned header at the start of pkt
+func parseHeader(pkt []byte) (header, error) {
+	var h header
+	if len(pkt) < HeaderSize || pkt[0] != Magic[0] || pkt[1] != Magic[1] {
+		return h, fmt.Errorf("not a versioned packet")
+	}
+	h.version = pkt[2]
+	if h.version != Version1 {
+		return h, fmt.Errorf("unsupported packet version %d", h.version)
+	}
+	h.flags = pkt[3]
+	h.sequence = binary.LittleEndian.Uint32(pkt[4:])
+	h.width = binary.LittleEndian.Uint16(pkt[8:])
+	h.height = binary.LittleEndian.Uint16(pkt[10:])
+	h.fragment = binary.LittleEndian.Uint16(pkt[12:])
+	h.fragments = binary.LittleEndian.Uint16(pkt[14:])
+	if h.fragment >= h.fragments {
+		return h, fmt.Errorf("fragment %d of %d", h.fragment, h.fragments)
+	}
+	return h, nil
+}
+
+// Return the length of the packet header and of each row's row number
+func (p Protocol) sizes() (headerSize, rowNumberSize int) {
+	if p == ProtocolV1 {
+		return HeaderSize, 2
+	}
+	return 4, 1
+}
+
+// AppendAck appends to dst the acknowledgement of frame sequence.
+func AppendAck(dst []byte, sequence uint32) []byte {
+	buf, _ := bytesbuffer.NewBuffer(bytesbuffer.LittleEndian)
+	h := header{version: Version1, flags: FlagAck, sequence: sequence, fragments: 1}
+	h.put(&buf)
+	return append(dst, buf.Bytes()...)
+}
+
+// ParseAck returns the frame sequence acknowledged by pkt.
+func ParseAck(pkt []byte) (uint32, error) {
+	h, err := parseHeader(pkt)
+	if err != nil {
+		return 0, err
+	}
+	if len(pkt) != HeaderSize || h.flags&FlagAck == 0 || h.width != 0 || h.height != 0 {
+		return 0, fmt.Errorf("not an acknowledgement")
+	}
+	return h.sequence, nil
+}
@@ -0,0 +1,272 @@
+// Package server is a software pixelpusher panel.  It receives the packets sent by a pixelpusher.Client, rebuilds
+// the frames and can save them as PNG files or show them in a browser, so the panel protocol can be developed and
+// tested without hardware.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jjcinaz/panelserver/pixelpusher"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultAddress is the UDP address panels listen on
+const DefaultAddress = ":5078"
+
+// Stats are counters of the packets and frames a Server has received
+type Stats struct {
+	Packets      uint64 // packets received
+	Frames       uint64 // frames completely received
+	Acks         uint64 // acknowledgements sent
+	Gaps         uint64 // frames missing from the sequence, i.e. never received at all
+	OutOfOrder   uint64 // packets of a frame older than one already received, these are dropped
+	Restarts     uint64 // times the sender started again from an earlier sequence
+	Errors       uint64 // packets which could not be decoded
+	LastSequence uint32 // sequence number of the newest frame
+	LastFrame    time.Time
+}
+
+// Server is a software panel
+type Server struct {
+	mutex   sync.Mutex
+	decoder *pixelpusher.Decoder
+	frame   *image.RGBA // copy of the last complete frame
+	started bool
+	// sequence of the last complete frame, so one resent while its acknowledgement was lost is not counted twice
+	completed     uint32
+	haveCompleted bool
+	stats         Stats
+	onFrame       func(image.Image, pixelpusher.Frame)
+	onError       func(error)
+	dumpDir       string
+}
+
+// Option configures a Server when passed to New
+type Option func(*Server)
+
+// WithFrameHandler sets a function called with each complete frame.  The image must not be kept after it returns.
+func WithFrameHandler(f func(image.Image, pixelpusher.Frame)) Option {
+	return func(s *Server) {
+		s.onFrame = f
+	}
+}
+
+// WithErrorHandler sets a function called with each packet which could not be decoded.
+func WithErrorHandler(f func(error)) Option {
+	return func(s *Server) {
+		s.onError = f
+	}
+}
+
+// WithDumpDir writes each complete frame to dir as frame-<sequence>.png, the sequence zero padded so
+// the files sort in order.
+func WithDumpDir(dir string) Option {
+	return func(s *Server) {
+		s.dumpDir = dir
+	}
+}
+
+// WithPalette sets the colors of pixelpusher.FormatMono pixels.
+func WithPalette(off, on color.Color) Option {
+	return func(s *Server) {
+		s.decoder.SetPalette(off, on)
+	}
+}
+
+// New returns a Server for a panel of rows and cols pixels speaking protocol.
+func New(protocol pixelpusher.Protocol, rows, cols int, opts ...Option) *Server {
+	s := &Server{
+		decoder: pixelpusher.NewDecoder(protocol, rows, cols),
+		frame:   image.NewRGBA(image.Rect(0, 0, cols, rows)),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ListenAndServe receives packets on the UDP address, DefaultAddress if empty, until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	if len(address) == 0 {
+		address = DefaultAddress
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, conn)
+}
+
+// Serve receives packets on conn, acknowledging frames which ask for it, until ctx is cancelled, then closes conn.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if ack := s.HandlePacket(buf[:n]); ack != nil {
+			conn.WriteTo(ack, addr)
+		}
+	}
+}
+
+// HandlePacket decodes a packet as if it had been received from the network.  It returns the acknowledgement to
+// send back if the packet completed a frame whose sender asked for one, otherwise nil.  A sender which restarts
+// from sequence 1 is acknowledged from its first frame.
+func (s *Server) HandlePacket(pkt []byte) []byte {
+	s.mutex.Lock()
+	s.stats.Packets++
+	f, err := s.decoder.Decode(pkt)
+	if err != nil {
+		if errors.Is(err, pixelpusher.ErrStale) {
+			s.stats.OutOfOrder++
+			err = nil
+		} else {
+			s.stats.Errors++
+		}
+		s.mutex.Unlock()
+		if err != nil && s.onError != nil {
+			s.onError(err)
+		}
+		return nil
+	}
+	if s.started && int32(f.Sequence-s.stats.LastSequence) < 0 {
+		// the decoder only accepts an older sequence from a restarted sender, start counting afresh
+		s.stats.Restarts++
+		s.haveCompleted = false
+	} else if s.started && f.Sequence != s.stats.LastSequence {
+		s.stats.Gaps += uint64(f.Sequence - s.stats.LastSequence - 1)
+	}
+	s.started = true
+	s.stats.LastSequence = f.Sequence
+	if !f.Complete {
+		s.mutex.Unlock()
+		return nil
+	}
+	var ack []byte
+	if f.Ack {
+		ack = pixelpusher.AppendAck(nil, f.Sequence)
+		s.stats.Acks++
+	}
+	if s.haveCompleted && f.Sequence == s.completed {
+		// a repeated packet of a frame already shown
+		s.mutex.Unlock()
+		return ack
+	}
+	s.haveCompleted, s.completed = true, f.Sequence
+	s.stats.Frames++
+	s.stats.LastFrame = time.Now()
+	img := s.decoder.Image()
+	if !s.frame.Rect.Eq(img.Rect) {
+		s.frame = image.NewRGBA(img.Rect)
+	}
+	copy(s.frame.Pix, img.Pix)
+	// the handlers run with the lock held so frames are delivered in order and s.frame is not replaced
+	defer s.mutex.Unlock()
+	if len(s.dumpDir) > 0 {
+		if err = s.dump(f.Sequence); err != nil && s.onError != nil {
+			s.onError(err)
+		}
+	}
+	if s.onFrame != nil {
+		s.onFrame(s.frame, f)
+	}
+	return ack
+}
+
+// Write the last frame to the dump directory
+// mutex MUST be locked when running this
+func (s *Server) dump(sequence uint32) error {
+	file, err := os.Create(filepath.Join(s.dumpDir, fmt.Sprintf("frame-%010d.png", sequence)))
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(file, s.frame); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Image returns a copy of the last complete frame.
+func (s *Server) Image() *image.RGBA {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	img := image.NewRGBA(s.frame.Rect)
+	copy(img.Pix, s.frame.Pix)
+	return img
+}
+
+// Stats returns the Server's counters.
+func (s *Server) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
+
+// WritePNG writes the last complete frame to w as a PNG.
+func (s *Server) WritePNG(w io.Writer) error {
+	return png.Encode(w, s.Image())
+}
+
+// ServeHTTP shows the panel in a browser.  / is a page showing the panel enlarged and updating as frames arrive,
+// /frame.png the last frame and /stats the Server's counters as JSON.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	case "/frame.png":
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		s.WritePNG(w)
+	case "/stats":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Stats())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<title>pixelpusher panel</title>
+<style>
+body { background: #222; color: #aaa; font-family: monospace; }
+img { width: 90vw; image-rendering: pixelated; border: 1px solid #444; }
+</style>
+</head>
+<body>
+<img id="panel" src="frame.png">
+<pre id="stats"></pre>
+<script>
+setInterval(function() {
+	document.getElementById("panel").src = "frame.png?" + Date.now();
+	fetch("stats").then(r => r.json()).then(s => {
+		document.getElementById("stats").textContent = JSON.stringify(s, null, 1);
+	});
+}, 250);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/jjcinaz/panelserver/pixelpusher"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Build a legacy packet of a 1 row, 2 column panel filled with v
+func legacyPacket(seq uint32, v byte) []byte {
+	pkt := binary.LittleEndian.AppendUint32(nil, seq)
+	return append(pkt, 0, v, v, v, v, v, v)
+}
+
+func TestServer_sequence(t *testing.T) {
+	var frames []uint32
+	s := New(pixelpusher.ProtocolLegacy, 1, 2, WithFrameHandler(func(img image.Image, f pixelpusher.Frame) {
+		frames = append(frames, f.Sequence)
+	}))
+	s.HandlePacket(legacyPacket(1, 10))
+	s.HandlePacket(legacyPacket(2, 20))
+	// frames 3 and 4 lost
+	s.HandlePacket(legacyPacket(5, 50))
+	// frame 4 arrives late and is dropped
+	s.HandlePacket(legacyPacket(4, 40))
+	s.HandlePacket([]byte{1, 2, 3})
+	st := s.Stats()
+	if st.Packets != 5 || st.Frames != 3 || st.Gaps != 2 || st.OutOfOrder != 1 || st.Errors != 1 || st.LastSequence != 5 {
+		t.Errorf("stats %+v", st)
+	}
+	if len(frames) != 3 || frames[2] != 5 {
+		t.Errorf("frames %v, want [1 2 5]", frames)
+	}
+	if c := s.Image().RGBAAt(1, 0); c.R != 50 {
+		t.Errorf("pixel %v, want the late frame ignored", c)
+	}
+
+	// the sender restarts, starting again from sequence 1
+	for seq := uint32(1); seq <= 3; seq++ {
+		s.HandlePacket(legacyPacket(seq, byte(100+seq)))
+	}
+	st = s.Stats()
+	if st.Frames != 6 || st.Restarts != 1 || st.Gaps != 2 || st.OutOfOrder != 1 || st.LastSequence != 3 {
+		t.Errorf("stats after restart %+v", st)
+	}
+	if c := s.Image().RGBAAt(1, 0); c.R != 103 {
+		t.Errorf("pixel %v after restart, want the restarted sender's frame", c)
+	}
+	// a sender jumping far back is also taken as a restart, a short way back is still a late packet
+	s.HandlePacket(legacyPacket(1000, 1))
+	s.HandlePacket(legacyPacket(600, 2))
+	s.HandlePacket(legacyPacket(590, 3))
+	if st = s.Stats(); st.Restarts != 2 || st.OutOfOrder != 2 || st.LastSequence != 600 {
+		t.Errorf("stats after jumping back %+v", st)
+	}
+}
+
+func TestServer_dump(t *testing.T) {
+	dir := t.TempDir()
+	s := New(pixelpusher.ProtocolLegacy, 1, 2, WithDumpDir(dir))
+	s.HandlePacket(legacyPacket(3, 30))
+	file, err := os.Open(filepath.Join(dir, "frame-0000000003.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(1, 0).RGBA(); r>>8 != 30 {
+		t.Errorf("dumped pixel %v", img.At(1, 0))
+	}
+}
+
+func TestServer_udp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan *image.RGBA, 1)
+	s := New(pixelpusher.ProtocolV1, 16, 16, WithFrameHandler(func(img image.Image, f pixelpusher.Frame) {
+		if f.Keyframe {
+			cp := image.NewRGBA(img.Bounds())
+			copy(cp.Pix, img.(*image.RGBA).Pix)
+			got <- cp
+		}
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, conn) }()
+
+	c, err := pixelpusher.NewClient("udp", conn.LocalAddr().String(), 32, 48,
+		pixelpusher.WithProtocol(pixelpusher.ProtocolV1), pixelpusher.WithMTU(512), pixelpusher.WithAck(time.Second, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: byte(x * 5), G: byte(y * 7), B: 99, A: 0xff})
+		}
+	}
+	if err = c.SendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case frame := <-got:
+		// the panel takes its size from the frames sent to it
+		if frame.Rect != img.Rect || !bytes.Equal(frame.Pix, img.Pix) {
+			t.Errorf("received frame differs from the one sent")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no frame received, stats %+v", s.Stats())
+	}
+	// the frame is acknowledged
+	for deadline := time.Now().Add(time.Second); !c.Health().Online; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("frame not acknowledged, stats %+v", s.Stats())
+		}
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+}
+
+func TestServer_restartAck(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(pixelpusher.ProtocolV1, 2, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, conn)
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	online := func(c *pixelpusher.Client, sequence uint32) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			if h := c.Health(); h.Online && h.Sequence == sequence && !h.Pending {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("health %+v, stats %+v", c.Health(), s.Stats())
+			}
+		}
+	}
+	newClient := func() *pixelpusher.Client {
+		c, err := pixelpusher.NewClient("udp", conn.LocalAddr().String(), 2, 2,
+			pixelpusher.WithProtocol(pixelpusher.ProtocolV1), pixelpusher.WithAck(time.Millisecond*50, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	c := newClient()
+	for seq := uint32(1); seq <= 5; seq++ {
+		c.SendImage(img)
+		online(c, seq)
+	}
+	c.Close()
+	// a restarted sender begins again at sequence 1 and is acknowledged by the panel which kept running
+	c = newClient()
+	defer c.Close()
+	c.SendImage(img)
+	online(c, 1)
+	if st := s.Stats(); st.Restarts != 1 || st.Frames != 6 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestServer_resent(t *testing.T) {
+	frames := 0
+	s := New(pixelpusher.ProtocolV1, 2, 2, WithFrameHandler(func(img image.Image, f pixelpusher.Frame) {
+		frames++
+	}))
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// capture the frame as sent rather than going through Serve, so it can be handed over twice
+	c, _ := pixelpusher.NewClient("udp", conn.LocalAddr().String(), 2, 2,
+		pixelpusher.WithProtocol(pixelpusher.ProtocolV1), pixelpusher.WithAck(time.Second, 1))
+	defer c.Close()
+	c.SendImage(image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the frame is resent after its acknowledgement was lost, it is acknowledged again but shown once
+	for i := 0; i < 2; i++ {
+		ack := s.HandlePacket(buf[:n])
+		if seq, err := pixelpusher.ParseAck(ack); err != nil || seq != 1 {
+			t.Errorf("acknowledgement %d: %d, %v", i, seq, err)
+		}
+	}
+	if st := s.Stats(); frames != 1 || st.Frames != 1 || st.Acks != 2 || st.Gaps != 0 {
+		t.Errorf("%d frames shown, stats %+v", frames, st)
+	}
+}
+
+func TestServer_http(t *testing.T) {
+	s := New(pixelpusher.ProtocolLegacy, 1, 2)
+	s.HandlePacket(legacyPacket(7, 200))
+	h := httptest.NewServer(s)
+	defer h.Close()
+
+	resp, err := h.Client().Get(h.URL + "/frame.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); img.Bounds().Dx() != 2 || r>>8 != 200 {
+		t.Errorf("frame.png %v pixel %v", img.Bounds(), img.At(0, 0))
+	}
+
+	resp, err = h.Client().Get(h.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st Stats
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil || st.LastSequence != 7 || st.Frames != 1 {
+		t.Errorf("stats %+v, %v", st, err)
+	}
+
+	resp, err = h.Client().Get(h.URL + "/nothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("status %d for an unknown path", resp.StatusCode)
+	}
+}